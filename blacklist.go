@@ -0,0 +1,50 @@
+package main
+
+import (
+	"./logger"
+	"sync"
+)
+
+// ipBlacklist tracks IPs the crawler should refuse to talk to - known-bad or
+// honeypot hosts curated by the operator. It's consulted before handshake on
+// both the dial path (redialFromRedis) and inbound connections (handle), so
+// a blacklisted IP never gets far enough to register a peer.
+type ipBlacklist struct {
+	mu  sync.RWMutex
+	ips map[string]bool
+}
+
+// newIPBlacklist seeds the blacklist from cfg.BlacklistIPs and whatever's
+// been persisted to Redis via AddToBlacklist across restarts.
+func newIPBlacklist(seed []string) *ipBlacklist {
+	b := &ipBlacklist{ips: make(map[string]bool, len(seed))}
+	for _, ip := range seed {
+		b.ips[ip] = true
+	}
+	persisted, err := red.GetBlacklist()
+	if err != nil {
+		logger.Error("newIPBlacklist: GetBlacklist err: ", err)
+		return b
+	}
+	for _, ip := range persisted {
+		b.ips[ip] = true
+	}
+	return b
+}
+
+// Contains reports whether ip has been blacklisted.
+func (b *ipBlacklist) Contains(ip string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.ips[ip]
+}
+
+// AddToBlacklist adds ip to the in-memory blacklist and persists it to
+// Redis so it's still blocked after a restart.
+func (pxy *proxy) AddToBlacklist(ip string) error {
+	pxy.blacklist.mu.Lock()
+	pxy.blacklist.ips[ip] = true
+	pxy.blacklist.mu.Unlock()
+
+	return red.AddBlacklistIP(ip)
+}
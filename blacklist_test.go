@@ -0,0 +1,14 @@
+package main
+
+import "testing"
+
+func TestIPBlacklistContains(t *testing.T) {
+	b := &ipBlacklist{ips: map[string]bool{"1.2.3.4": true}}
+
+	if !b.Contains("1.2.3.4") {
+		t.Fatal("Contains(1.2.3.4) = false, want true")
+	}
+	if b.Contains("5.6.7.8") {
+		t.Fatal("Contains(5.6.7.8) = true, want false")
+	}
+}
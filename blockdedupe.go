@@ -0,0 +1,49 @@
+package main
+
+import (
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+	"gopkg.in/fatih/set.v0"
+)
+
+const defaultBlockDedupeSize = 1024
+
+// blockDedupe suppresses reprocessing a NewBlockMsg we've already seen from
+// another peer - during propagation storms the same block routinely arrives
+// from several peers in a row, and re-running the bestState/bestHei
+// bookkeeping and re-broadcast for each is wasted work. Eviction is by
+// capacity rather than real recency, same as the bounded knownBlocks/knownTxs
+// sets in ethpeer.
+type blockDedupe struct {
+	seen    *set.Set
+	size    int
+	deduped int64
+}
+
+func newBlockDedupe(size int) *blockDedupe {
+	if size <= 0 {
+		size = defaultBlockDedupeSize
+	}
+	return &blockDedupe{seen: set.New(), size: size}
+}
+
+// Seen reports whether hash has already been recorded, and records it
+// otherwise, tallying the duplicate hits along the way.
+func (d *blockDedupe) Seen(hash common.Hash) bool {
+	if d.seen.Has(hash) {
+		atomic.AddInt64(&d.deduped, 1)
+		return true
+	}
+	for d.seen.Size() >= d.size {
+		d.seen.Pop()
+	}
+	d.seen.Add(hash)
+	return false
+}
+
+// Deduped returns the running count of NewBlockMsg arrivals dropped as
+// duplicates.
+func (d *blockDedupe) Deduped() int64 {
+	return atomic.LoadInt64(&d.deduped)
+}
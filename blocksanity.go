@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// maxExtraDataSize bounds a header's extra-data field. Real chains keep it
+// well under this, so a header claiming more is more likely forged than
+// legitimately using a fat vanity field.
+const maxExtraDataSize = 1024
+
+// maxFutureBlockTime is how far into the future a block's timestamp may
+// claim to be before checkBlockSanity rejects it as implausible.
+const maxFutureBlockTime = 15 * time.Second
+
+// maxBlockNumberRegression is how far behind a known head a header's number
+// may be before checkBlockSanity treats it as implausible rather than an
+// ordinary reorg or a second honest peer announcing the same height it was
+// last told about. Equal-height announcements are expected on ordinary
+// multi-peer gossip (bestHeader can already have advanced from a different
+// peer's earlier announcement or heartbeatPeers' polling) and are always
+// allowed; a duplicate of an already-seen block is filtered separately by
+// newBlockDedupe.
+const maxBlockNumberRegression = 2
+
+// checkBlockSanity applies lightweight, protocol-agnostic sanity checks to a
+// header announced via NewBlockMsg, without needing the full chain to
+// validate against: its number must not regress far behind a known parent's,
+// its timestamp must not be implausibly far in the future, and its
+// extraData must not be unreasonably large. It's not a substitute for real
+// PoW/consensus validation - it's a cheap trip-wire against a single lying
+// peer skewing bestState with a bogus block.
+func checkBlockSanity(header *types.Header, knownParent *types.Header, now time.Time) error {
+	if header.Number == nil {
+		return fmt.Errorf("header has no number")
+	}
+	if knownParent != nil && knownParent.Number != nil {
+		minAllowed := new(big.Int).Sub(knownParent.Number, big.NewInt(maxBlockNumberRegression))
+		if header.Number.Cmp(minAllowed) < 0 {
+			return fmt.Errorf("number %s regresses more than %d blocks behind known head %s", header.Number, maxBlockNumberRegression, knownParent.Number)
+		}
+	}
+	if maxTime := uint64(now.Add(maxFutureBlockTime).Unix()); header.Time > maxTime {
+		return fmt.Errorf("timestamp %d is more than %s in the future", header.Time, maxFutureBlockTime)
+	}
+	if len(header.Extra) > maxExtraDataSize {
+		return fmt.Errorf("extraData is %d bytes, over the %d limit", len(header.Extra), maxExtraDataSize)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestCheckBlockSanityAllowsEqualHeight(t *testing.T) {
+	parent := &types.Header{Number: big.NewInt(100)}
+	header := &types.Header{Number: big.NewInt(100)}
+
+	if err := checkBlockSanity(header, parent, time.Now()); err != nil {
+		t.Fatalf("checkBlockSanity(equal height) = %v, want nil", err)
+	}
+}
+
+func TestCheckBlockSanityAllowsSmallRegression(t *testing.T) {
+	parent := &types.Header{Number: big.NewInt(100)}
+	header := &types.Header{Number: big.NewInt(100 - maxBlockNumberRegression)}
+
+	if err := checkBlockSanity(header, parent, time.Now()); err != nil {
+		t.Fatalf("checkBlockSanity(small regression) = %v, want nil", err)
+	}
+}
+
+func TestCheckBlockSanityRejectsLargeRegression(t *testing.T) {
+	parent := &types.Header{Number: big.NewInt(100)}
+	header := &types.Header{Number: big.NewInt(100 - maxBlockNumberRegression - 1)}
+
+	if err := checkBlockSanity(header, parent, time.Now()); err == nil {
+		t.Fatal("checkBlockSanity(large regression) = nil, want error")
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultDialBackoffBase = 30 * time.Second
+	defaultDialBackoffMax  = 30 * time.Minute
+
+	// dialResultWait is how long redialFromRedis gives a freshly-dialed node
+	// to show up in pxy.ethpeerset before treating the attempt as a failure.
+	dialResultWait = 5 * time.Second
+)
+
+// dialBackoff tracks consecutive AddPeer failures per node ID and grows the
+// re-dial interval exponentially, so a dead node doesn't get re-dialed on
+// every single redialFromRedis tick. Failure counts are persisted to Redis
+// via red.WriteDialFailures so a restart doesn't forget and start hammering
+// dead nodes again from zero.
+type dialBackoff struct {
+	mu       sync.Mutex
+	failures map[string]int
+	until    map[string]time.Time
+	base     time.Duration
+	max      time.Duration
+}
+
+func newDialBackoff(base, max time.Duration) *dialBackoff {
+	if base <= 0 {
+		base = defaultDialBackoffBase
+	}
+	if max <= 0 {
+		max = defaultDialBackoffMax
+	}
+	return &dialBackoff{
+		failures: red.GetDialFailures(),
+		until:    make(map[string]time.Time),
+		base:     base,
+		max:      max,
+	}
+}
+
+// Allow reports whether id is currently eligible to be dialed - true if it
+// has never failed, or its backoff window has already elapsed.
+func (b *dialBackoff) Allow(id string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	until, ok := b.until[id]
+	if !ok {
+		return true
+	}
+	return !time.Now().Before(until)
+}
+
+// backoffFor doubles base n-1 times, stopping as soon as it reaches max,
+// instead of computing 1<<(n-1) directly - for n much beyond 62 that shift
+// overflows int64 and can wrap back around to a small (or negative) value,
+// silently defeating the cap this is supposed to enforce.
+func backoffFor(base, max time.Duration, n int) time.Duration {
+	backoff := base
+	for i := 1; i < n && backoff < max; i++ {
+		backoff *= 2
+		if backoff <= 0 {
+			return max
+		}
+	}
+	if backoff > max {
+		return max
+	}
+	return backoff
+}
+
+// RecordFailure grows id's backoff window exponentially, capped at max, and
+// persists the new failure count to Redis.
+func (b *dialBackoff) RecordFailure(id string) {
+	b.mu.Lock()
+	b.failures[id]++
+	n := b.failures[id]
+	backoff := backoffFor(b.base, b.max, n)
+	b.until[id] = time.Now().Add(backoff)
+	b.mu.Unlock()
+
+	if err := red.WriteDialFailures(id, n); err != nil {
+		logger.Error("dialBackoff: WriteDialFailures err: ", err)
+	}
+}
+
+// RecordSuccess clears id's backoff state, both in memory and in Redis, so a
+// node that comes back online is dialed on the normal schedule again.
+func (b *dialBackoff) RecordSuccess(id string) {
+	b.mu.Lock()
+	delete(b.failures, id)
+	delete(b.until, id)
+	b.mu.Unlock()
+
+	if err := red.ClearDialFailures(id); err != nil {
+		logger.Error("dialBackoff: ClearDialFailures err: ", err)
+	}
+}
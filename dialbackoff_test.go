@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffForCapsAtMaxWithoutOverflow(t *testing.T) {
+	base := 30 * time.Second
+	max := 30 * time.Minute
+
+	// n=40 would overflow a raw 1<<(n-1) shift multiplied by base long
+	// before this - it must still land exactly at max, not wrap around to
+	// something small or negative.
+	if got := backoffFor(base, max, 40); got != max {
+		t.Fatalf("backoffFor(n=40) = %v, want %v", got, max)
+	}
+	if got := backoffFor(base, max, 1000); got != max {
+		t.Fatalf("backoffFor(n=1000) = %v, want %v", got, max)
+	}
+}
+
+func TestBackoffForDoublesBelowMax(t *testing.T) {
+	base := 30 * time.Second
+	max := 30 * time.Minute
+
+	if got, want := backoffFor(base, max, 1), base; got != want {
+		t.Fatalf("backoffFor(n=1) = %v, want %v", got, want)
+	}
+	if got, want := backoffFor(base, max, 3), 4*base; got != want {
+		t.Fatalf("backoffFor(n=3) = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+
+	"./logger"
+)
+
+// ExportDOT writes a Graphviz/DOT snapshot of the currently connected peers
+// to w: one node per peer (labeled with a short id and its known TD) plus an
+// edge from "local" to each. It's a one-time visualization aid, not a live
+// feed, so it only covers the direct connections pxy.ethpeerset knows about
+// right now - it doesn't attempt to show peer-to-peer topology beyond that.
+func (pxy *proxy) ExportDOT(w io.Writer) error {
+	if _, err := fmt.Fprintln(w, "digraph crawl {"); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(w, `	"local" [shape=box];`); err != nil {
+		return err
+	}
+	for _, p := range pxy.ethpeerset.SortedPeers() {
+		id := p.P.ID().String()
+		short := id
+		if len(short) > 8 {
+			short = short[:8]
+		}
+		_, td := p.Head()
+		if td == nil {
+			td = big.NewInt(0)
+		}
+		if _, err := fmt.Fprintf(w, "\t%q [label=%q];\n", id, fmt.Sprintf("%s\\nTD %s", short, td)); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "\t%q -> %q;\n", "local", id); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+// dotHandler serves the same snapshot ExportDOT produces, for pulling a
+// topology dump with curl instead of wiring up a one-off script.
+func (pxy *proxy) dotHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/vnd.graphviz")
+	if err := pxy.ExportDOT(w); err != nil {
+		logger.Error("ExportDOT err: ", err)
+	}
+}
@@ -0,0 +1,118 @@
+package ethpeer
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+)
+
+// newTestPeer creates two Peers wired together by a p2p.MsgPipe, so
+// Handshake, SendNewBlock and friends can be exercised end-to-end without a
+// real TCP connection. It returns one side of the pair and the raw
+// p2p.MsgReadWriter of the other side, for driving/inspecting the exchange
+// directly in a test.
+func newTestPeer(version uint32) (*Peer, p2p.MsgReadWriter) {
+	rw1, rw2 := p2p.MsgPipe()
+	peer := NewPeer(version, p2p.NewPeer(discover.NodeID{}, "test peer", nil), rw1)
+	return peer, rw2
+}
+
+func TestHandshakeSuccess(t *testing.T) {
+	peer, rw := newTestPeer(eth63)
+
+	genesis := common.Hash{1}
+	td := big.NewInt(100)
+	head := common.Hash{2}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- peer.Handshake(1, td, head, genesis, ForkID{})
+	}()
+
+	if err := p2p.ExpectMsg(rw, StatusMsg, &statusData{
+		ProtocolVersion: uint32(eth63),
+		NetworkId:       1,
+		TD:              td,
+		CurrentBlock:    head,
+		GenesisBlock:    genesis,
+	}); err != nil {
+		t.Fatalf("status message mismatch: %v", err)
+	}
+	if err := p2p.Send(rw, StatusMsg, &statusData{
+		ProtocolVersion: uint32(eth63),
+		NetworkId:       1,
+		TD:              td,
+		CurrentBlock:    head,
+		GenesisBlock:    genesis,
+	}); err != nil {
+		t.Fatalf("send status: %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("Handshake: %v", err)
+	}
+}
+
+func TestHandshakeNilTDRejected(t *testing.T) {
+	peer, rw := newTestPeer(eth63)
+
+	genesis := common.Hash{1}
+	td := big.NewInt(100)
+	head := common.Hash{2}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- peer.Handshake(1, td, head, genesis, ForkID{})
+	}()
+
+	if _, err := rw.ReadMsg(); err != nil {
+		t.Fatalf("read status: %v", err)
+	}
+	if err := p2p.Send(rw, StatusMsg, &statusData{
+		ProtocolVersion: uint32(eth63),
+		NetworkId:       1,
+		TD:              nil,
+		CurrentBlock:    head,
+		GenesisBlock:    genesis,
+	}); err != nil {
+		t.Fatalf("send status: %v", err)
+	}
+	if err := <-errc; err == nil {
+		t.Fatal("Handshake succeeded despite nil TD")
+	}
+}
+
+func TestHandshakeGenesisMismatch(t *testing.T) {
+	peer, rw := newTestPeer(eth63)
+
+	genesis := common.Hash{1}
+	foreignGenesis := common.Hash{9}
+	td := big.NewInt(100)
+	head := common.Hash{2}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- peer.Handshake(1, td, head, genesis, ForkID{})
+	}()
+
+	if _, err := rw.ReadMsg(); err != nil {
+		t.Fatalf("read status: %v", err)
+	}
+	if err := p2p.Send(rw, StatusMsg, &statusData{
+		ProtocolVersion: uint32(eth63),
+		NetworkId:       1,
+		TD:              td,
+		CurrentBlock:    head,
+		GenesisBlock:    foreignGenesis,
+	}); err != nil {
+		t.Fatalf("send status: %v", err)
+	}
+	if err := <-errc; err == nil {
+		t.Fatal("Handshake succeeded despite genesis mismatch")
+	}
+	if got := peer.Genesis(); got != foreignGenesis {
+		t.Fatalf("Genesis() after mismatch = %x, want %x", got, foreignGenesis)
+	}
+}
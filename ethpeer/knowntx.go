@@ -0,0 +1,94 @@
+package ethpeer
+
+import (
+	"hash/fnv"
+
+	"github.com/ethereum/go-ethereum/common"
+	"gopkg.in/fatih/set.v0"
+)
+
+// knownTxSet tracks which transaction hashes a peer is already known to
+// have, so we never propagate them back to it. NewPeerWithKnownTxSet picks
+// between the exact set.Set-backed implementation (the NewPeer default) and
+// a bloom filter one that trades a small false-positive rate for much lower
+// memory at high peer counts.
+type knownTxSet interface {
+	Add(hash common.Hash)
+	Has(hash common.Hash) bool
+}
+
+// exactKnownTxs is the default knownTxSet: an exact set capped at max
+// entries, dropping an arbitrary entry once full exactly as
+// MarkTransaction always has.
+type exactKnownTxs struct {
+	set *set.Set
+	max int
+}
+
+func newExactKnownTxs(max int) *exactKnownTxs {
+	return &exactKnownTxs{set: set.New(), max: max}
+}
+
+func (s *exactKnownTxs) Add(hash common.Hash) {
+	for s.set.Size() >= s.max {
+		s.set.Pop()
+	}
+	s.set.Add(hash)
+}
+
+func (s *exactKnownTxs) Has(hash common.Hash) bool {
+	return s.set.Has(hash)
+}
+
+const (
+	bloomKnownTxsBits = 1 << 20 // 128KB of bits, sized for defaultMaxKnownTxs entries at BloomFPRate
+	bloomKnownTxsK    = 4       // number of hash functions
+)
+
+// BloomFPRate is the approximate false-positive rate of the bloom-filter
+// backed knownTxSet once it holds defaultMaxKnownTxs entries. A false positive only
+// means we skip re-sending a transaction the peer most likely already has.
+const BloomFPRate = 0.02
+
+// bloomKnownTxs is a knownTxSet backed by a fixed-size bloom filter instead
+// of an exact set, at a fraction of the memory per peer. It never clears
+// bits, since forgetting would only ever produce false negatives (a
+// harmless extra send), never the false positives we're trying to bound.
+type bloomKnownTxs struct {
+	bits []byte
+}
+
+func newBloomKnownTxs() *bloomKnownTxs {
+	return &bloomKnownTxs{bits: make([]byte, bloomKnownTxsBits/8)}
+}
+
+func (b *bloomKnownTxs) indexes(hash common.Hash) [bloomKnownTxsK]uint32 {
+	h1 := fnv.New32a()
+	h1.Write(hash[:])
+	base := h1.Sum32()
+
+	h2 := fnv.New32()
+	h2.Write(hash[:])
+	step := h2.Sum32()
+
+	var idxs [bloomKnownTxsK]uint32
+	for i := range idxs {
+		idxs[i] = (base + uint32(i)*step) % bloomKnownTxsBits
+	}
+	return idxs
+}
+
+func (b *bloomKnownTxs) Add(hash common.Hash) {
+	for _, idx := range b.indexes(hash) {
+		b.bits[idx/8] |= 1 << (idx % 8)
+	}
+}
+
+func (b *bloomKnownTxs) Has(hash common.Hash) bool {
+	for _, idx := range b.indexes(hash) {
+		if b.bits[idx/8]&(1<<(idx%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
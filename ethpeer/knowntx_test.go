@@ -0,0 +1,51 @@
+package ethpeer
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestBloomKnownTxsHasAfterAdd(t *testing.T) {
+	b := newBloomKnownTxs()
+	hash := common.HexToHash("0x1")
+	if b.Has(hash) {
+		t.Fatal("expected hash to be unknown before Add")
+	}
+	b.Add(hash)
+	if !b.Has(hash) {
+		t.Fatal("expected hash to be known after Add")
+	}
+}
+
+// BenchmarkKnownTxsMemory reports allocated bytes for tracking defaultMaxKnownTxs
+// hashes with each knownTxSet implementation, at a peer count similar to a
+// 300-peer crawl (run with -benchmem to see the per-peer difference).
+func BenchmarkKnownTxsMemory(b *testing.B) {
+	const peers = 300
+
+	b.Run("exact", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for p := 0; p < peers; p++ {
+				s := newExactKnownTxs(defaultMaxKnownTxs)
+				for h := 0; h < defaultMaxKnownTxs; h++ {
+					s.Add(common.BigToHash(big.NewInt(int64(h))))
+				}
+			}
+		}
+	})
+
+	b.Run("bloom", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for p := 0; p < peers; p++ {
+				s := newBloomKnownTxs()
+				for h := 0; h < defaultMaxKnownTxs; h++ {
+					s.Add(common.BigToHash(big.NewInt(int64(h))))
+				}
+			}
+		}
+	})
+}
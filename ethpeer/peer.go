@@ -17,9 +17,14 @@
 package ethpeer
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"math"
 	"math/big"
+	"net"
+	"sort"
 	"sync"
 	"time"
 
@@ -28,27 +33,56 @@ import (
 	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/rlp"
 	"gopkg.in/fatih/set.v0"
+
+	"../logger"
 )
 
 var (
 	errClosed            = errors.New("peer set is closed")
 	errAlreadyRegistered = errors.New("peer is already registered")
 	errNotRegistered     = errors.New("peer is not registered")
+	errRateLimited       = errors.New("peer send rate limited")
 )
 
 const (
 	eth62 = 62
 	eth63 = 63
+	eth64 = 64
+	eth65 = 65
 )
 
 // Official short name of the protocol used during capability negotiation.
 var ProtocolName = "eth"
 
 // Supported versions of the eth protocol (first is primary).
-var ProtocolVersions = []uint{eth63, eth62}
+var ProtocolVersions = []uint{eth65, eth64, eth63, eth62}
 
 // Number of implemented message corresponding to different protocol versions.
-var ProtocolLengths = []uint64{17, 8}
+var ProtocolLengths = []uint64{17, 17, 17, 8}
+
+// Caps returns the p2p.Cap advertised for every supported protocol version
+// in ProtocolVersions, so callers building a p2p.Server's capability list
+// stay in sync with ProtocolVersions automatically instead of hand-rolling
+// a parallel list that can drift.
+func Caps() []p2p.Cap {
+	caps := make([]p2p.Cap, len(ProtocolVersions))
+	for i, version := range ProtocolVersions {
+		caps[i] = p2p.Cap{Name: ProtocolName, Version: version}
+	}
+	return caps
+}
+
+// ProtocolLengthForVersion returns the number of message codes implemented
+// by the given eth protocol version (see ProtocolLengths), or 0 if version
+// isn't one of ProtocolVersions.
+func ProtocolLengthForVersion(version uint32) uint64 {
+	for i, v := range ProtocolVersions {
+		if uint32(v) == version {
+			return ProtocolLengths[i]
+		}
+	}
+	return 0
+}
 
 const ProtocolMaxMsgSize = 10 * 1024 * 1024 // Maximum cap on the size of a protocol message
 const (
@@ -67,19 +101,36 @@ const (
 	NodeDataMsg    = 0x0e
 	GetReceiptsMsg = 0x0f
 	ReceiptsMsg    = 0x10
+
+	// Protocol messages belonging to eth/65
+	NewPooledTransactionHashesMsg = 0x08
+	GetPooledTransactionsMsg      = 0x09
+	PooledTransactionsMsg         = 0x0a
 )
 const (
-	maxKnownTxs      = 32768 // Maximum transactions hashes to keep in the known list (prevent DOS)
-	maxKnownBlocks   = 1024  // Maximum block hashes to keep in the known list (prevent DOS)
+	// defaultMaxKnownTxs and defaultMaxKnownBlocks are the known-item cap
+	// sizes NewPeer uses when the caller doesn't override them (see
+	// NewPeerWithLimits). Raising them trades memory (each peer holds its
+	// own set, so the cost multiplies by peer count) for fewer redundant
+	// re-sends of content the peer most likely already has; lowering them
+	// trades the reverse, useful on memory-constrained deployments.
+	defaultMaxKnownTxs    = 32768
+	defaultMaxKnownBlocks = 1024
+
 	handshakeTimeout = 5 * time.Second
 )
 
 // PeerInfo represents a short summary of the Ethereum sub-protocol metadata known
 // about a connected peer.
 type PeerInfo struct {
-	Version    uint32   `json:"version"`    // Ethereum protocol version negotiated
-	Difficulty *big.Int `json:"difficulty"` // Total difficulty of the peer's blockchain
-	Head       string   `json:"head"`       // SHA3 hash of the peer's best owned block
+	ID               string   `json:"id"`               // Node ID of the peer
+	RemoteAddr       string   `json:"remoteAddr"`       // Network address the peer connected from
+	IP               string   `json:"ip"`               // Just the address portion of RemoteAddr, to correlate with Redis node records
+	Version          uint32   `json:"version"`          // Ethereum protocol version negotiated
+	Difficulty       *big.Int `json:"difficulty"`       // Total difficulty of the peer's blockchain
+	Head             string   `json:"head"`             // SHA3 hash of the peer's best owned block
+	ConnectedSeconds int64    `json:"connectedSeconds"` // How long the peer has been connected
+	LatencyMs        int64    `json:"latencyMs"`        // Round-trip time of the most recently answered heartbeat probe, see Latency
 }
 
 type Peer struct {
@@ -88,40 +139,163 @@ type Peer struct {
 	P       *p2p.Peer
 	rw      p2p.MsgReadWriter
 
-	version  uint32      // Protocol version negotiated
-	forkDrop *time.Timer // Timed connection dropper if forks aren't validated in time
+	version     uint32      // Protocol version negotiated
+	forkDrop    *time.Timer // Timed connection dropper if forks aren't validated in time
+	connectedAt time.Time   // When the peer was registered
+
+	head           common.Hash
+	td             *big.Int
+	headNumber     uint64    // highest block number announced via SetHeadNumber
+	lastHeadUpdate time.Time // last time SetHead observed a TD increase
+	lock           sync.RWMutex
 
-	head common.Hash
-	td   *big.Int
-	lock sync.RWMutex
+	knownTxs       knownTxSet // Set of transaction hashes known to be known by this peer
+	knownBlocks    *set.Set   // Set of block hashes known to be known by this peer
+	maxKnownBlocks int        // Cap on knownBlocks.Size(), enforced by MarkBlock
 
-	knownTxs    *set.Set // Set of transaction hashes known to be known by this peer
-	knownBlocks *set.Set // Set of block hashes known to be known by this peer
+	acceptForeignGenesis bool // If set, a genesis mismatch during Handshake is observed rather than fatal
+
+	limiter      RateLimiter // Optional outgoing rate limiter, nil disables limiting
+	blockOnLimit bool        // Block for a free token instead of dropping the message
+
+	msgCounts map[uint64]uint64 // Messages received from this peer, keyed by msg.Code
+
+	score int64 // Reputation score, adjusted via Adjust; see PeerSet.TopScored
+
+	pingSentAt time.Time     // When MarkPingSent last sent a heartbeat probe, zero if none outstanding
+	pingMisses int           // Consecutive heartbeat probes that went unanswered
+	latency    time.Duration // Round-trip time of the most recently answered heartbeat probe
 }
 
 func NewPeer(version uint32, p *p2p.Peer, rw p2p.MsgReadWriter) *Peer {
+	return NewPeerWithKnownTxSet(version, p, rw, false)
+}
+
+// NewPeerWithKnownTxSet is like NewPeer but lets the caller pick a
+// bloom-filter backed knownTxs set instead of the exact set.Set default,
+// trading a BloomFPRate false-positive rate for far less memory per peer
+// once hundreds of peers are connected. It uses defaultMaxKnownTxs and
+// defaultMaxKnownBlocks - use NewPeerWithLimits to override either.
+func NewPeerWithKnownTxSet(version uint32, p *p2p.Peer, rw p2p.MsgReadWriter, useBloom bool) *Peer {
+	return NewPeerWithLimits(version, p, rw, useBloom, defaultMaxKnownBlocks, defaultMaxKnownTxs)
+}
+
+// NewPeerWithLimits is the fully-configurable Peer constructor every other
+// NewPeer* function bottoms out in. maxKnownBlocks/maxKnownTxs cap how many
+// entries MarkBlock/MarkTransaction will retain for this peer before
+// evicting the oldest - each connected peer holds its own set, so raising
+// these multiplies the extra memory by peer count, while lowering them
+// means more redundant re-sends of content the peer most likely already
+// has. <= 0 falls back to the matching default.
+func NewPeerWithLimits(version uint32, p *p2p.Peer, rw p2p.MsgReadWriter, useBloom bool, maxKnownBlocks, maxKnownTxs int) *Peer {
 	id := p.ID()
 
+	if maxKnownBlocks <= 0 {
+		maxKnownBlocks = defaultMaxKnownBlocks
+	}
+	if maxKnownTxs <= 0 {
+		maxKnownTxs = defaultMaxKnownTxs
+	}
+
+	var knownTxs knownTxSet
+	if useBloom {
+		knownTxs = newBloomKnownTxs()
+	} else {
+		knownTxs = newExactKnownTxs(maxKnownTxs)
+	}
+
 	return &Peer{
 		P:       p,
 		rw:      rw,
 		version: version,
 		id:      fmt.Sprintf("%x", id[:]),
 		// id:          fmt.Sprintf("%x", id[:8]),
-		td:          big.NewInt(0),
-		knownTxs:    set.New(),
-		knownBlocks: set.New(),
+		td:             big.NewInt(0),
+		knownTxs:       knownTxs,
+		knownBlocks:    set.New(),
+		maxKnownBlocks: maxKnownBlocks,
+		connectedAt:    time.Now(),
+		lastHeadUpdate: time.Now(),
+		msgCounts:      make(map[uint64]uint64),
+	}
+}
+
+// CountMsg records receipt of an inbound message with the given protocol
+// msg.Code, for later inspection via MsgStats. Callers should invoke this
+// from the protocol read loop for every message received from the peer.
+func (p *Peer) CountMsg(code uint64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.msgCounts[code]++
+}
+
+// MsgStats returns a snapshot of messages received per code so far.
+func (p *Peer) MsgStats() map[uint64]uint64 {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	stats := make(map[uint64]uint64, len(p.msgCounts))
+	for code, count := range p.msgCounts {
+		stats[code] = count
+	}
+	return stats
+}
+
+// MsgStatsReset returns a snapshot of messages received per code and clears
+// the counters, for interval-based reporting.
+func (p *Peer) MsgStatsReset() map[uint64]uint64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	stats := make(map[uint64]uint64, len(p.msgCounts))
+	for code, count := range p.msgCounts {
+		stats[code] = count
 	}
+	p.msgCounts = make(map[uint64]uint64)
+	return stats
+}
+
+// Uptime returns how long this peer has been connected.
+func (p *Peer) Uptime() time.Duration {
+	return time.Since(p.connectedAt)
+}
+
+// RemoteAddr returns the network address this peer connected from, or nil
+// if the underlying p2p.Peer has none - e.g. an inbound pipe-based test peer
+// that was never dialed over a real socket. p2p.Peer's own RemoteAddr can
+// panic in that case rather than returning nil, so this recovers instead of
+// propagating the panic.
+func (p *Peer) RemoteAddr() (addr net.Addr) {
+	if p.P == nil {
+		return nil
+	}
+	defer func() {
+		if recover() != nil {
+			addr = nil
+		}
+	}()
+	return p.P.RemoteAddr()
 }
 
 // Info gathers and returns a collection of metadata known about a peer.
 func (p *Peer) Info() *PeerInfo {
 	hash, td := p.Head()
 
+	var remoteAddr, ip string
+	if addr := p.RemoteAddr(); addr != nil {
+		remoteAddr = addr.String()
+		if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+			ip = host
+		}
+	}
+
 	return &PeerInfo{
-		Version:    p.version,
-		Difficulty: td,
-		Head:       hash.Hex(),
+		ID:               p.id,
+		RemoteAddr:       remoteAddr,
+		IP:               ip,
+		Version:          p.version,
+		Difficulty:       td,
+		Head:             hash.Hex(),
+		ConnectedSeconds: int64(p.Uptime().Seconds()),
+		LatencyMs:        int64(p.Latency() / time.Millisecond),
 	}
 }
 
@@ -138,15 +312,135 @@ func (p *Peer) Head() (hash common.Hash, td *big.Int) {
 	return hash, new(big.Int).Set(p.td)
 }
 
-// SetHead updates the head hash and total difficulty of the peer.
+// SetHead updates the head hash and total difficulty of the peer. If td
+// advances the peer's previously recorded difficulty, lastHeadUpdate is
+// bumped to now, so Stalled can tell a peer that's genuinely stuck from one
+// that just hasn't had a reason to report a higher TD yet.
 func (p *Peer) SetHead(hash common.Hash, td *big.Int) {
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
+	// A nil td would panic td.Cmp/p.td.Set below - defensively treat it as
+	// zero rather than trusting every caller to have already rejected it
+	// (see readStatus's ErrNilTD check for the handshake path).
+	if td == nil {
+		td = new(big.Int)
+	}
+	if td.Cmp(p.td) > 0 {
+		p.lastHeadUpdate = time.Now()
+	}
 	copy(p.head[:], hash[:])
 	p.td.Set(td)
 	// fmt.Println("sethead:",p.head,":",p.td.Text(10))
 }
+
+// SetHeadNumber records n as the peer's head block number if it's higher
+// than what's already recorded. It's independent of SetHead's hash/TD pair
+// since NewBlockMsg and NewBlockHashesMsg both carry a number alongside (or
+// instead of) a TD.
+func (p *Peer) SetHeadNumber(n uint64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if n > p.headNumber {
+		p.headNumber = n
+	}
+}
+
+// HeadNumber returns the highest block number this peer has announced.
+func (p *Peer) HeadNumber() uint64 {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.headNumber
+}
+
+// Stalled reports whether this peer's TD hasn't advanced in at least window,
+// e.g. because it stopped syncing or is stuck on a fork we've moved past.
+func (p *Peer) Stalled(window time.Duration) bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return time.Since(p.lastHeadUpdate) >= window
+}
+
+// IdleDuration returns how long it's been since this peer's head last
+// advanced, for callers ranking peers by activity (e.g. eviction policies).
+func (p *Peer) IdleDuration() time.Duration {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return time.Since(p.lastHeadUpdate)
+}
+
+// MarkPingSent records that a heartbeat probe was just sent to this peer, so
+// PingOutstanding reports true until the matching RecordPong arrives.
+func (p *Peer) MarkPingSent() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.pingSentAt = time.Now()
+}
+
+// PingOutstanding reports whether a heartbeat probe has been sent but not
+// yet answered by RecordPong.
+func (p *Peer) PingOutstanding() bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return !p.pingSentAt.IsZero()
+}
+
+// RecordPong answers the outstanding heartbeat probe, if any, updating
+// Latency from the round-trip time and resetting the consecutive-miss
+// counter. It returns the measured latency, or zero if no probe was
+// outstanding (e.g. a stray reply after MissHeartbeat already gave up on it).
+func (p *Peer) RecordPong() time.Duration {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.pingSentAt.IsZero() {
+		return 0
+	}
+	p.latency = time.Since(p.pingSentAt)
+	p.pingSentAt = time.Time{}
+	p.pingMisses = 0
+	return p.latency
+}
+
+// MissHeartbeat records that an outstanding heartbeat probe went
+// unanswered, clearing it so the next heartbeat tick sends a fresh one, and
+// returns the resulting consecutive-miss count for the caller to compare
+// against its disconnect threshold.
+func (p *Peer) MissHeartbeat() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.pingSentAt = time.Time{}
+	p.pingMisses++
+	return p.pingMisses
+}
+
+// Latency returns the round-trip time of the most recently answered
+// heartbeat probe, or zero if none has ever been answered.
+func (p *Peer) Latency() time.Duration {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.latency
+}
+
+// Adjust adds delta (positive or negative) to p's reputation score. Callers
+// decide what earns or costs points - see the scoring events documented in
+// main's peerscore.go - Adjust itself just accumulates.
+func (p *Peer) Adjust(delta int64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.score += delta
+}
+
+// Score returns p's current reputation score.
+func (p *Peer) Score() int64 {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.score
+}
+
+// Version returns the negotiated eth protocol version, e.g. 63 for eth/63.
+func (p *Peer) Version() uint32 {
+	return p.version
+}
 func (p *Peer) SetGenesis(g common.Hash) {
 	p.lock.Lock()
 	defer p.lock.Unlock()
@@ -155,6 +449,25 @@ func (p *Peer) SetGenesis(g common.Hash) {
 	// p.td.Set(td)
 	// fmt.Println("sethead:",p.head,":",p.td.Text(10))
 }
+// SetAcceptForeignGenesis controls whether Handshake treats a genesis
+// mismatch as fatal (the default) or as something to observe: the peer's
+// reported genesis is still recorded via SetGenesis, but Handshake returns
+// ErrForeignGenesis instead of the usual ErrGenesisBlockMismatch, letting a
+// caller crawling multiple chains keep the connection around in a
+// passive/observe-only mode rather than disconnecting.
+func (p *Peer) SetAcceptForeignGenesis(accept bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.acceptForeignGenesis = accept
+}
+
+// Genesis returns the genesis block hash this peer reported in its last
+// status exchange. readStatus records it via SetGenesis on every status
+// message, including a mismatched one - Handshake returning
+// ErrGenesisBlockMismatch/ErrForeignGenesis doesn't leave the peer's
+// reported genesis unrecorded, so a caller running multi-chain
+// observe-only mode can still tell which foreign chain this peer belongs
+// to instead of only seeing it in the error string.
 func (p *Peer) Genesis() (g common.Hash) {
 	p.lock.Lock()
 	defer p.lock.Unlock()
@@ -169,7 +482,7 @@ func (p *Peer) Genesis() (g common.Hash) {
 // never be propagated to this particular peer.
 func (p *Peer) MarkBlock(hash common.Hash) {
 	// If we reached the memory allowance, drop a previously known block hash
-	for p.knownBlocks.Size() >= maxKnownBlocks {
+	for p.knownBlocks.Size() >= p.maxKnownBlocks {
 		p.knownBlocks.Pop()
 	}
 	p.knownBlocks.Add(hash)
@@ -178,22 +491,51 @@ func (p *Peer) MarkBlock(hash common.Hash) {
 // MarkTransaction marks a transaction as known for the peer, ensuring that it
 // will never be propagated to this particular peer.
 func (p *Peer) MarkTransaction(hash common.Hash) {
-	// If we reached the memory allowance, drop a previously known transaction hash
-	for p.knownTxs.Size() >= maxKnownTxs {
-		p.knownTxs.Pop()
-	}
 	p.knownTxs.Add(hash)
 }
 
 // SendTransactions sends transactions to the peer and includes the hashes
 // in its transaction hash set for future reference.
 func (p *Peer) SendTransactions(txs types.Transactions) error {
+	if err := p.awaitSendSlot(); err != nil {
+		return err
+	}
 	for _, tx := range txs {
 		p.knownTxs.Add(tx.Hash())
 	}
 	return p2p.Send(p.rw, TxMsg, txs)
 }
 
+// ErrPooledTransactionsUnsupported is returned by SendPooledTransactionHashes
+// and RequestPooledTransactions when the peer negotiated below eth/65, which
+// doesn't know these message codes.
+var ErrPooledTransactionsUnsupported = errors.New("ethpeer: peer does not support eth/65 pooled transactions")
+
+// SendPooledTransactionHashes announces transactions to the peer by hash
+// only, letting it decide whether to fetch the full bodies via
+// RequestPooledTransactions - the eth/65 mempool gossip mode.
+func (p *Peer) SendPooledTransactionHashes(hashes []common.Hash) error {
+	if p.version < eth65 {
+		return ErrPooledTransactionsUnsupported
+	}
+	if err := p.awaitSendSlot(); err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		p.knownTxs.Add(hash)
+	}
+	return p2p.Send(p.rw, NewPooledTransactionHashesMsg, hashes)
+}
+
+// RequestPooledTransactions fetches the full bodies of previously announced
+// pooled transactions by hash.
+func (p *Peer) RequestPooledTransactions(hashes []common.Hash) error {
+	if p.version < eth65 {
+		return ErrPooledTransactionsUnsupported
+	}
+	return p2p.Send(p.rw, GetPooledTransactionsMsg, hashes)
+}
+
 // SendNewBlockHashes announces the availability of a number of blocks through
 // a hash notification.
 type newBlockHashesData []struct {
@@ -219,6 +561,9 @@ func (p *Peer) SendNewBlockHashes(hashes []common.Hash, numbers []uint64) error
 
 // SendNewBlock propagates an entire block to a remote peer.
 func (p *Peer) SendNewBlock(block *types.Block, td *big.Int) error {
+	if err := p.awaitSendSlot(); err != nil {
+		return err
+	}
 	p.knownBlocks.Add(block.Hash())
 	return p2p.Send(p.rw, NewBlockMsg, []interface{}{block, td})
 }
@@ -245,32 +590,123 @@ func (p *Peer) SendNodeData(data [][]byte) error {
 	return p2p.Send(p.rw, NodeDataMsg, data)
 }
 
+// SendBlockHeadersRLP forwards an already RLP encoded BlockHeadersMsg reply
+// to the remote peer without decoding and re-encoding it, for relaying a
+// reply received from one peer straight on to another.
+func (p *Peer) SendBlockHeadersRLP(headers rlp.RawValue) error {
+	return p2p.Send(p.rw, BlockHeadersMsg, headers)
+}
+
 // SendReceiptsRLP sends a batch of transaction receipts, corresponding to the
 // ones requested from an already RLP encoded format.
 func (p *Peer) SendReceiptsRLP(receipts []rlp.RawValue) error {
 	return p2p.Send(p.rw, ReceiptsMsg, receipts)
 }
 
+// hashOrNumber is the field type of a GetBlockHeadersMsg query's Origin - it
+// carries either a hash or a number over the wire, exactly one of which is
+// non-zero, mirroring go-ethereum's eth/downloader/peer.go GetBlockHeaders
+// wire format so unmodified eth peers can decode our requests.
+type hashOrNumber struct {
+	Hash   common.Hash
+	Number uint64
+}
+
+// EncodeRLP is a specialized encoder for hashOrNumber to encode only one of
+// the two contained union fields.
+func (hn *hashOrNumber) EncodeRLP(w io.Writer) error {
+	if hn.Hash == (common.Hash{}) {
+		return rlp.Encode(w, hn.Number)
+	}
+	if hn.Number != 0 {
+		return fmt.Errorf("both origin hash (%x) and number (%d) provided", hn.Hash, hn.Number)
+	}
+	return rlp.Encode(w, hn.Hash)
+}
+
+// DecodeRLP is a specialized decoder for hashOrNumber to decode the contents
+// into either a block hash or a block number.
+func (hn *hashOrNumber) DecodeRLP(s *rlp.Stream) error {
+	_, size, _ := s.Kind()
+	origin, err := s.Raw()
+	if err == nil {
+		switch {
+		case size == 32:
+			err = rlp.DecodeBytes(origin, &hn.Hash)
+		default:
+			err = rlp.DecodeBytes(origin, &hn.Number)
+		}
+	}
+	return err
+}
+
+// getBlockHeadersData is the wire format of a GetBlockHeadersMsg request.
+type getBlockHeadersData struct {
+	Origin  hashOrNumber
+	Amount  uint64
+	Skip    uint64
+	Reverse bool
+}
+
 // RequestOneHeader is a wrapper around the header query functions to fetch a
-// single header. It is used solely by the fetcher.
-// func (p *Peer) RequestOneHeader(hash common.Hash) error {
-// 	p.Log().Debug("Fetching single header", "hash", hash)
-// 	return p2p.Send(p.rw, GetBlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Hash: hash}, Amount: uint64(1), Skip: uint64(0), Reverse: false})
-// }
+// single header.
+func (p *Peer) RequestOneHeader(hash common.Hash) error {
+	return p2p.Send(p.rw, GetBlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Hash: hash}, Amount: uint64(1), Skip: uint64(0), Reverse: false})
+}
 
 // RequestHeadersByHash fetches a batch of blocks' headers corresponding to the
 // specified header query, based on the hash of an origin block.
-// func (p *Peer) RequestHeadersByHash(origin common.Hash, amount int, skip int, reverse bool) error {
-// 	p.Log().Debug("Fetching batch of headers", "count", amount, "fromhash", origin, "skip", skip, "reverse", reverse)
-// 	return p2p.Send(p.rw, GetBlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Hash: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse})
-// }
+func (p *Peer) RequestHeadersByHash(origin common.Hash, amount int, skip int, reverse bool) error {
+	return p2p.Send(p.rw, GetBlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Hash: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse})
+}
 
 // RequestHeadersByNumber fetches a batch of blocks' headers corresponding to the
 // specified header query, based on the number of an origin block.
-// func (p *Peer) RequestHeadersByNumber(origin uint64, amount int, skip int, reverse bool) error {
-// 	p.Log().Debug("Fetching batch of headers", "count", amount, "fromnum", origin, "skip", skip, "reverse", reverse)
-// 	return p2p.Send(p.rw, GetBlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Number: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse})
-// }
+func (p *Peer) RequestHeadersByNumber(origin uint64, amount int, skip int, reverse bool) error {
+	return p2p.Send(p.rw, GetBlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Number: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse})
+}
+
+// MaxHeaderFetch is the largest number of headers we'll ask a peer for in a
+// single GetBlockHeadersMsg, matching go-ethereum's own downloader limit.
+const MaxHeaderFetch = 192
+
+// MaxHeaderRangeFetch caps how many headers RequestBlocksByRange will ask
+// for across all its batches in one call, guarding against a caller passing
+// an absurd [from, to] that would otherwise queue thousands of requests.
+const MaxHeaderRangeFetch = 1 << 20
+
+// errInvalidRange is returned by RequestBlocksByRange when from > to or the
+// range spans more headers than MaxHeaderRangeFetch allows.
+var errInvalidRange = errors.New("invalid block range")
+
+// RequestBlocksByRange issues GetBlockHeaders requests covering [from, to]
+// (inclusive), split into MaxHeaderFetch-sized batches so a single request
+// stays within what well-behaved peers are willing to serve. It returns the
+// origin block number of each batch fired, in order, as a handle the caller
+// can correlate against the BlockHeadersMsg replies arriving on the normal
+// handleBlockHeadersMsg path - this method only issues the requests, it
+// doesn't itself wait for or aggregate the responses.
+func (p *Peer) RequestBlocksByRange(from, to uint64) ([]uint64, error) {
+	if from > to {
+		return nil, errInvalidRange
+	}
+	if to-from+1 > MaxHeaderRangeFetch {
+		return nil, errInvalidRange
+	}
+
+	var batches []uint64
+	for origin := from; origin <= to; origin += MaxHeaderFetch {
+		amount := MaxHeaderFetch
+		if remaining := to - origin + 1; remaining < uint64(amount) {
+			amount = int(remaining)
+		}
+		if err := p.RequestHeadersByNumber(origin, amount, 0, false); err != nil {
+			return batches, err
+		}
+		batches = append(batches, origin)
+	}
+	return batches, nil
+}
 
 // RequestBodies fetches a batch of blocks' bodies corresponding to the hashes
 // specified.
@@ -279,59 +715,149 @@ func (p *Peer) SendReceiptsRLP(receipts []rlp.RawValue) error {
 // 	return p2p.Send(p.rw, GetBlockBodiesMsg, hashes)
 // }
 
+// SendGetBlockBodiesRLP forwards an already RLP encoded GetBlockBodiesMsg
+// request to the remote peer without decoding and re-encoding it, for
+// relaying a request received from one peer straight on to another.
+func (p *Peer) SendGetBlockBodiesRLP(request rlp.RawValue) error {
+	return p2p.Send(p.rw, GetBlockBodiesMsg, request)
+}
+
+// SendGetBlockHeadersRLP forwards an already RLP encoded GetBlockHeadersMsg
+// request to the remote peer without decoding and re-encoding it, for
+// relaying a request received from one peer straight on to another.
+func (p *Peer) SendGetBlockHeadersRLP(request rlp.RawValue) error {
+	return p2p.Send(p.rw, GetBlockHeadersMsg, request)
+}
+
 // RequestNodeData fetches a batch of arbitrary data from a node's known state
 // data, corresponding to the specified hashes.
-// func (p *Peer) RequestNodeData(hashes []common.Hash) error {
-// 	p.Log().Debug("Fetching batch of state data", "count", len(hashes))
-// 	return p2p.Send(p.rw, GetNodeDataMsg, hashes)
-// }
+func (p *Peer) RequestNodeData(hashes []common.Hash) error {
+	return p2p.Send(p.rw, GetNodeDataMsg, hashes)
+}
+
+// MaxReceiptFetch is the largest number of receipts we'll ask a peer for in
+// a single GetReceiptsMsg, matching go-ethereum's own downloader limit so we
+// stay within what well-behaved peers expect to serve in one reply.
+const MaxReceiptFetch = 256
+
+// errTooManyReceiptsRequested is returned when RequestReceipts is asked for
+// more hashes than MaxReceiptFetch allows.
+var errTooManyReceiptsRequested = errors.New("too many receipts requested")
 
 // RequestReceipts fetches a batch of transaction receipts from a remote node.
-// func (p *Peer) RequestReceipts(hashes []common.Hash) error {
-// 	p.Log().Debug("Fetching batch of receipts", "count", len(hashes))
-// 	return p2p.Send(p.rw, GetReceiptsMsg, hashes)
-// }
+func (p *Peer) RequestReceipts(hashes []common.Hash) error {
+	if len(hashes) > MaxReceiptFetch {
+		return errTooManyReceiptsRequested
+	}
+	return p2p.Send(p.rw, GetReceiptsMsg, hashes)
+}
+// ForkID is the EIP-2124 style fork identifier eth/64+ peers exchange during
+// the status handshake, so a node can be recognized as incompatible with our
+// chain without maintaining a full list of past and future fork blocks.
+type ForkID struct {
+	Hash [4]byte // CRC32 checksum of the genesis and passed fork block numbers
+	Next uint64  // Block number of the next upcoming fork, 0 if none known
+}
+
 type statusData struct {
 	ProtocolVersion uint32
 	NetworkId       uint64
 	TD              *big.Int
 	CurrentBlock    common.Hash
 	GenesisBlock    common.Hash
+	ForkID          ForkID `rlp:"optional"` // eth/64+ only, absent for eth/62-63 peers
 }
 
 // Handshake executes the eth protocol handshake, negotiating version number,
-// network IDs, difficulties, head and genesis blocks.
-func (p *Peer) Handshake(network uint64, td *big.Int, head common.Hash, genesis common.Hash) error {
+// network IDs, difficulties, head and genesis blocks. For eth/64+ peers it
+// also exchanges a ForkID; eth/62-63 peers simply omit it since the field is
+// RLP-optional, so we fall back cleanly to the older handshake shape.
+//
+// It's a thin wrapper around HandshakeContext with a handshakeTimeout-bound
+// context, kept for callers that don't need external cancellation.
+func (p *Peer) Handshake(network uint64, td *big.Int, head common.Hash, genesis common.Hash, forkID ForkID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	defer cancel()
+	err := p.HandshakeContext(ctx, network, td, head, genesis, forkID)
+	if err == context.DeadlineExceeded {
+		return p2p.DiscReadTimeout
+	}
+	return err
+}
+
+// HandshakeContext is like Handshake but aborts as soon as ctx is canceled
+// instead of waiting out a fixed timer, so a shutdown path can tear down
+// in-flight handshakes immediately rather than blocking up to
+// handshakeTimeout. The status-send and status-read goroutines are left to
+// finish on their own into the buffered errc channel, so no goroutine leaks
+// past ctx being canceled.
+func (p *Peer) HandshakeContext(ctx context.Context, network uint64, td *big.Int, head common.Hash, genesis common.Hash, forkID ForkID) error {
 	// Send out own handshake in a new thread
 	errc := make(chan error, 2)
 	var status statusData // safe to read after two values have been received from errc
 
+	out := &statusData{
+		ProtocolVersion: uint32(p.version),
+		NetworkId:       network,
+		TD:              td,
+		CurrentBlock:    head,
+		GenesisBlock:    genesis,
+	}
+	if p.version >= eth64 {
+		out.ForkID = forkID
+	}
+
 	go func() {
-		errc <- p2p.Send(p.rw, StatusMsg, &statusData{
-			ProtocolVersion: uint32(p.version),
-			NetworkId:       network,
-			TD:              td,
-			CurrentBlock:    head,
-			GenesisBlock:    genesis,
-		})
+		errc <- p2p.Send(p.rw, StatusMsg, out)
 	}()
 	go func() {
 		errc <- p.readStatus(network, &status, genesis)
 	}()
-	timeout := time.NewTimer(handshakeTimeout)
-	defer timeout.Stop()
+	var foreignGenesis error
 	for i := 0; i < 2; i++ {
 		select {
 		case err := <-errc:
+			if err == ErrForeignGenesis {
+				foreignGenesis = err
+				continue
+			}
 			if err != nil {
 				return err
 			}
-		case <-timeout.C:
-			return p2p.DiscReadTimeout
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 	}
 	p.td, p.head = status.TD, status.CurrentBlock
-	return nil
+	return foreignGenesis
+}
+
+// HandshakeAuto behaves like Handshake, but on a protocol version mismatch
+// it retries with the next lower version from ProtocolVersions instead of
+// giving up outright, so we can still talk to peers that only speak an
+// older eth/NN. p.version is left set to whichever version the successful
+// attempt negotiated with.
+func (p *Peer) HandshakeAuto(network uint64, td *big.Int, head common.Hash, genesis common.Hash, forkID ForkID) error {
+	start := 0
+	for i, v := range ProtocolVersions {
+		if uint32(v) == p.version {
+			start = i
+			break
+		}
+	}
+	var err error
+	for _, v := range ProtocolVersions[start:] {
+		p.version = uint32(v)
+		err = p.Handshake(network, td, head, genesis, forkID)
+		if err == nil || err == ErrForeignGenesis {
+			return err
+		}
+		pe, ok := err.(*peerError)
+		if !ok || pe.code != ErrProtocolVersionMismatch {
+			return err
+		}
+	}
+	return err
 }
 
 type errCode int
@@ -346,11 +872,30 @@ const (
 	ErrNoStatusMsg
 	ErrExtraStatusMsg
 	ErrSuspendedPeer
+	ErrNilTD
 )
 
 func errResp(code errCode, format string, v ...interface{}) error {
-	return fmt.Errorf("%v - %v", code, fmt.Sprintf(format, v...))
+	return &peerError{code: code, message: fmt.Sprintf("%v - %v", code, fmt.Sprintf(format, v...))}
 }
+
+// peerError carries the errCode behind an errResp error so callers such as
+// HandshakeAuto can distinguish a version mismatch from other handshake
+// failures without parsing the message string.
+type peerError struct {
+	code    errCode
+	message string
+}
+
+func (e *peerError) Error() string { return e.message }
+
+// ErrForeignGenesis is returned by Handshake in place of ErrGenesisBlockMismatch
+// when the peer has SetAcceptForeignGenesis(true) - the peer's reported
+// genesis is still recorded via SetGenesis, letting a multi-chain crawler
+// keep the connection around in a passive/observe-only mode instead of
+// tearing it down.
+var ErrForeignGenesis = errors.New("ethpeer: peer genesis mismatch, observing only")
+
 func (p *Peer) readStatus(network uint64, status *statusData, genesis common.Hash) (err error) {
 	msg, err := p.rw.ReadMsg()
 	if err != nil {
@@ -366,9 +911,17 @@ func (p *Peer) readStatus(network uint64, status *statusData, genesis common.Has
 	if err := msg.Decode(&status); err != nil {
 		return errResp(ErrDecode, "msg %v: %v", msg, err)
 	}
+	if status.TD == nil {
+		return errResp(ErrNilTD, "status TD is nil")
+	}
 	if status.GenesisBlock != genesis {
+		p.SetGenesis(status.GenesisBlock)
+		if p.acceptForeignGenesis {
+			return ErrForeignGenesis
+		}
 		return errResp(ErrGenesisBlockMismatch, "%x (!= %x)", status.GenesisBlock[:8], genesis[:8])
 	}
+	p.SetGenesis(status.GenesisBlock)
 	if status.NetworkId != network {
 		return errResp(ErrNetworkIdMismatch, "%d (!= %d)", status.NetworkId, network)
 	}
@@ -379,6 +932,14 @@ func (p *Peer) readStatus(network uint64, status *statusData, genesis common.Has
 }
 
 // String implements fmt.Stringer.
+// Disconnect terminates the underlying p2p connection with the given reason,
+// logging the peer id, negotiated version and reason so drops can be
+// audited after the fact.
+func (p *Peer) Disconnect(reason p2p.DiscReason) {
+	logger.Info("disconnecting peer:", p.id, " version:", p.version, " reason:", reason)
+	p.P.Disconnect(reason)
+}
+
 func (p *Peer) String() string {
 	return fmt.Sprintf("Peer %s [%s]", p.id,
 		fmt.Sprintf("eth/%2d", p.version),
@@ -460,6 +1021,54 @@ func (ps *PeerSet) PeersWithoutBlock(hash common.Hash) []*Peer {
 	return list
 }
 
+// PropagationLess orders two candidate peers for PropagationTargets - the
+// peers that sort first are the ones handed the full block. The default
+// strategy favors peers reporting the lowest total difficulty, on the theory
+// that they're furthest behind and least likely to have already picked the
+// block up some other way. It's a package variable so an operator can swap
+// in a different strategy (e.g. random selection) without touching callers.
+var PropagationLess = func(a, b *Peer) bool {
+	_, tdA := a.Head()
+	_, tdB := b.Head()
+	if tdA == nil {
+		return tdB != nil
+	}
+	if tdB == nil {
+		return false
+	}
+	return tdA.Cmp(tdB) < 0
+}
+
+// PropagationFanout returns how many of n candidate peers should receive a
+// full block broadcast, mirroring go-ethereum's sqrt(peerCount) heuristic:
+// enough peers to disseminate the block quickly without every peer
+// re-forwarding it to every other peer.
+func PropagationFanout(n int) int {
+	if n <= 0 {
+		return 0
+	}
+	if fanout := int(math.Sqrt(float64(n))); fanout > 1 {
+		return fanout
+	}
+	return 1
+}
+
+// PropagationTargets returns up to max peers that don't yet have the given
+// block, ordered by PropagationLess, for capping broadcast fan-out instead
+// of sending to every peer in the set. If max <= 0, it defaults to
+// PropagationFanout(len(candidates)).
+func (ps *PeerSet) PropagationTargets(hash common.Hash, max int) []*Peer {
+	candidates := ps.PeersWithoutBlock(hash)
+	if max <= 0 {
+		max = PropagationFanout(len(candidates))
+	}
+	sort.Slice(candidates, func(i, j int) bool { return PropagationLess(candidates[i], candidates[j]) })
+	if len(candidates) > max {
+		candidates = candidates[:max]
+	}
+	return candidates
+}
+
 // PeersWithoutTx retrieves a list of peers that do not have a given transaction
 // in their set of known hashes.
 func (ps *PeerSet) PeersWithoutTx(hash common.Hash) []*Peer {
@@ -495,27 +1104,221 @@ func (ps *PeerSet) BestPeer() *Peer {
 		if bestTd == nil {
 			bestTd = td
 		}
-		if bestPeer == nil || td.Cmp(bestTd) > 0 {
+		switch {
+		case bestPeer == nil, td.Cmp(bestTd) > 0:
+			bestPeer, bestTd = p, td
+		case td.Cmp(bestTd) == 0 && p.Score() > bestPeer.Score():
 			bestPeer, bestTd = p, td
 		}
 	}
 	return bestPeer
 }
+
+// TopScored returns up to n peers sorted by Score, highest first. n <= 0
+// returns every peer, sorted.
+func (ps *PeerSet) TopScored(n int) []*Peer {
+	ps.lock.RLock()
+	list := make([]*Peer, 0, len(ps.peers))
+	for _, p := range ps.peers {
+		list = append(list, p)
+	}
+	ps.lock.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Score() > list[j].Score() })
+	if n > 0 && n < len(list) {
+		list = list[:n]
+	}
+	return list
+}
+
+// AllPeer returns a shallow copy of the peer set, safe for the caller to
+// range over without holding ps's lock and without racing concurrent
+// Register/Unregister calls.
 func (ps *PeerSet) AllPeer() map[string]*Peer {
 	ps.lock.RLock()
 	defer ps.lock.RUnlock()
 
-	return ps.peers
+	peers := make(map[string]*Peer, len(ps.peers))
+	for id, p := range ps.peers {
+		peers[id] = p
+	}
+	return peers
+}
+
+// SortedPeers returns a shallow copy of the peer set ordered by node ID, for
+// dumps/exports that should come out byte-identical run to run given the
+// same connected peers, unlike ranging over AllPeer's map directly.
+func (ps *PeerSet) SortedPeers() []*Peer {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	list := make([]*Peer, 0, len(ps.peers))
+	for _, p := range ps.peers {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].id < list[j].id })
+	return list
+}
+
+// ForEach calls fn for every peer in the set while holding ps's read lock,
+// stopping early if fn returns false. Callers must not register or
+// unregister peers from within fn, since that would deadlock on ps's lock.
+func (ps *PeerSet) ForEach(fn func(*Peer) bool) {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	for _, p := range ps.peers {
+		if !fn(p) {
+			return
+		}
+	}
+}
+
+// Snapshot builds a consistent, point-in-time copy of every peer's Info(),
+// sorted by total difficulty descending. Unlike AllPeer it hands the caller
+// plain data instead of live *Peer pointers, so it's safe to pass straight
+// to something like an HTTP handler without exposing peers to concurrent
+// mutation from outside PeerSet.
+func (ps *PeerSet) Snapshot() []*PeerInfo {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	infos := make([]*PeerInfo, 0, len(ps.peers))
+	for _, p := range ps.peers {
+		infos = append(infos, p.Info())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Difficulty.Cmp(infos[j].Difficulty) > 0
+	})
+	return infos
+}
+
+// MsgStats aggregates MsgStats across every peer in the set, keyed by peer
+// id then msg.Code, so a caller can rank peers by traffic to spot chatty or
+// spammy ones.
+func (ps *PeerSet) MsgStats() map[string]map[uint64]uint64 {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	stats := make(map[string]map[uint64]uint64, len(ps.peers))
+	for id, p := range ps.peers {
+		stats[id] = p.MsgStats()
+	}
+	return stats
+}
+
+// MedianTD returns the median total difficulty across all peers with a
+// known head, a more sybil-resistant view of the chain tip than BestPeer's
+// single highest value. Returns nil for an empty set.
+func (ps *PeerSet) MedianTD() *big.Int {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	tds := make([]*big.Int, 0, len(ps.peers))
+	for _, p := range ps.peers {
+		if p == nil {
+			continue
+		}
+		if _, td := p.Head(); td != nil {
+			tds = append(tds, td)
+		}
+	}
+	if len(tds) == 0 {
+		return nil
+	}
+	sort.Slice(tds, func(i, j int) bool { return tds[i].Cmp(tds[j]) < 0 })
+	return new(big.Int).Set(tds[len(tds)/2])
+}
+
+// PeersAboveTD retrieves the peers whose reported total difficulty is
+// strictly greater than td.
+func (ps *PeerSet) PeersAboveTD(td *big.Int) []*Peer {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	list := make([]*Peer, 0, len(ps.peers))
+	for _, p := range ps.peers {
+		if p == nil {
+			continue
+		}
+		if _, ptd := p.Head(); ptd != nil && ptd.Cmp(td) > 0 {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+// StalledPeers retrieves the peers whose TD hasn't advanced in at least
+// window, for pruning non-syncing nodes out of a crawl.
+func (ps *PeerSet) StalledPeers(window time.Duration) []*Peer {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	list := make([]*Peer, 0)
+	for _, p := range ps.peers {
+		if p == nil {
+			continue
+		}
+		if p.Stalled(window) {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+// VersionCounts tallies how many registered peers speak each negotiated
+// protocol version, e.g. {62: 3, 63: 12}.
+func (ps *PeerSet) VersionCounts() map[uint32]int {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	counts := make(map[uint32]int)
+	for _, p := range ps.peers {
+		if p == nil {
+			continue
+		}
+		counts[p.version]++
+	}
+	return counts
+}
+
+// PeersByVersion retrieves a list of peers negotiated on the given protocol
+// version.
+func (ps *PeerSet) PeersByVersion(v uint32) []*Peer {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	list := make([]*Peer, 0, len(ps.peers))
+	for _, p := range ps.peers {
+		if p == nil {
+			continue
+		}
+		if p.version == v {
+			list = append(list, p)
+		}
+	}
+	return list
 }
 
 // Close disconnects all peers.
 // No new peers can be registered after Close has returned.
-// func (ps *PeerSet) Close() {
-// 	ps.lock.Lock()
-// 	defer ps.lock.Unlock()
-
-// 	for _, p := range ps.peers {
-// 		p.Disconnect(p2p.DiscQuitting)
-// 	}
-// 	ps.closed = true
-// }
+func (ps *PeerSet) Close() {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	for _, p := range ps.peers {
+		p.P.Disconnect(p2p.DiscQuitting)
+	}
+	ps.closed = true
+}
+
+// DisconnectAll drops every peer currently in the set with the given reason,
+// for mass eviction (e.g. a chain reorg or shutdown) without closing the set.
+func (ps *PeerSet) DisconnectAll(reason p2p.DiscReason) {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	for _, p := range ps.peers {
+		p.Disconnect(reason)
+	}
+}
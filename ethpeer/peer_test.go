@@ -0,0 +1,200 @@
+package ethpeer
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+func newTestPeerWithID(id discover.NodeID) *Peer {
+	p := p2p.NewPeer(id, "test peer", nil)
+	return NewPeer(eth63, p, nil)
+}
+
+func TestPeerSetCloseRejectsRegister(t *testing.T) {
+	ps := NewPeerSet()
+
+	if err := ps.Register(newTestPeerWithID(discover.NodeID{1})); err != nil {
+		t.Fatalf("Register before Close: %v", err)
+	}
+
+	ps.Close()
+
+	if err := ps.Register(newTestPeerWithID(discover.NodeID{2})); err != errClosed {
+		t.Fatalf("Register after Close: got %v, want %v", err, errClosed)
+	}
+}
+
+// TestCapsMatchesProtocolVersions guards against Caps drifting out of sync
+// with ProtocolVersions if a version is ever added or removed from one but
+// not the other.
+func TestCapsMatchesProtocolVersions(t *testing.T) {
+	caps := Caps()
+	if len(caps) != len(ProtocolVersions) {
+		t.Fatalf("Caps returned %d entries, want %d", len(caps), len(ProtocolVersions))
+	}
+	for i, version := range ProtocolVersions {
+		if caps[i].Name != ProtocolName {
+			t.Fatalf("Caps[%d].Name = %q, want %q", i, caps[i].Name, ProtocolName)
+		}
+		if caps[i].Version != version {
+			t.Fatalf("Caps[%d].Version = %d, want %d", i, caps[i].Version, version)
+		}
+	}
+}
+
+// TestMarkBlockRespectsConfiguredLimit checks that NewPeerWithLimits'
+// maxKnownBlocks override actually bounds MarkBlock, not just the compiled
+// default.
+func TestMarkBlockRespectsConfiguredLimit(t *testing.T) {
+	p := NewPeerWithLimits(eth63, p2p.NewPeer(discover.NodeID{1}, "test peer", nil), nil, false, 3, 0)
+
+	for i := 0; i < 10; i++ {
+		p.MarkBlock(common.BigToHash(big.NewInt(int64(i))))
+	}
+
+	if size := p.knownBlocks.Size(); size != 3 {
+		t.Fatalf("knownBlocks.Size() = %d, want 3", size)
+	}
+}
+
+// TestSortedPeersOrdersByID checks that SortedPeers returns a stable,
+// node-ID-ordered view regardless of the set's internal map iteration order,
+// so dumps built from it come out identical across runs.
+func TestSortedPeersOrdersByID(t *testing.T) {
+	ps := NewPeerSet()
+
+	ids := []discover.NodeID{{3}, {1}, {2}}
+	for _, id := range ids {
+		if err := ps.Register(newTestPeerWithID(id)); err != nil {
+			t.Fatalf("Register: %v", err)
+		}
+	}
+
+	sorted := ps.SortedPeers()
+	if len(sorted) != len(ids) {
+		t.Fatalf("SortedPeers() returned %d peers, want %d", len(sorted), len(ids))
+	}
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i-1].id >= sorted[i].id {
+			t.Fatalf("SortedPeers() not ordered: %q before %q", sorted[i-1].id, sorted[i].id)
+		}
+	}
+}
+
+// TestRecordPongMeasuresLatencyAndResetsMisses checks the heartbeat
+// bookkeeping: RecordPong only reports a latency (and resets the miss
+// counter) when a ping was actually outstanding.
+func TestRecordPongMeasuresLatencyAndResetsMisses(t *testing.T) {
+	p := newTestPeerWithID(discover.NodeID{1})
+
+	if got := p.RecordPong(); got != 0 {
+		t.Fatalf("RecordPong with no ping outstanding = %v, want 0", got)
+	}
+
+	p.MissHeartbeat()
+	p.MissHeartbeat()
+
+	p.MarkPingSent()
+	if !p.PingOutstanding() {
+		t.Fatalf("PingOutstanding() after MarkPingSent = false, want true")
+	}
+
+	if got := p.RecordPong(); got <= 0 {
+		t.Fatalf("RecordPong() = %v, want > 0", got)
+	}
+	if p.PingOutstanding() {
+		t.Fatalf("PingOutstanding() after RecordPong = true, want false")
+	}
+	if got := p.Latency(); got <= 0 {
+		t.Fatalf("Latency() = %v, want > 0", got)
+	}
+
+	// A prior miss streak is cleared by a successful pong, not carried
+	// forward into the next one.
+	if got := p.MissHeartbeat(); got != 1 {
+		t.Fatalf("MissHeartbeat() after RecordPong = %d, want 1", got)
+	}
+}
+
+// TestSendBlockHeadersRLPForwardsBytesUnchanged checks that
+// SendBlockHeadersRLP puts the given rlp.RawValue on the wire verbatim as a
+// BlockHeadersMsg, rather than re-encoding it.
+func TestSendBlockHeadersRLPForwardsBytesUnchanged(t *testing.T) {
+	peer, rw := newTestPeer(eth63)
+
+	headers := []*types.Header{{Number: big.NewInt(1)}, {Number: big.NewInt(2)}}
+	raw, err := rlp.EncodeToBytes(headers)
+	if err != nil {
+		t.Fatalf("encoding test headers: %v", err)
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- peer.SendBlockHeadersRLP(raw) }()
+
+	msg, err := rw.ReadMsg()
+	if err != nil {
+		t.Fatalf("ReadMsg: %v", err)
+	}
+	if msg.Code != BlockHeadersMsg {
+		t.Fatalf("msg.Code = %d, want %d", msg.Code, BlockHeadersMsg)
+	}
+
+	var got []*types.Header
+	if err := msg.Decode(&got); err != nil {
+		t.Fatalf("decoding forwarded headers: %v", err)
+	}
+	if len(got) != len(headers) {
+		t.Fatalf("got %d headers, want %d", len(got), len(headers))
+	}
+	for i, h := range got {
+		if h.Number.Cmp(headers[i].Number) != 0 {
+			t.Fatalf("header[%d].Number = %v, want %v", i, h.Number, headers[i].Number)
+		}
+	}
+
+	if err := <-errc; err != nil {
+		t.Fatalf("SendBlockHeadersRLP: %v", err)
+	}
+}
+
+// TestProtocolLengthForVersionBoundary checks the eth/62 boundary called out
+// by the request that added it: GetNodeDataMsg (0x0d = 13) is within range
+// for eth/63 (length 17) but out of range for eth/62 (length 8).
+func TestProtocolLengthForVersionBoundary(t *testing.T) {
+	if length := ProtocolLengthForVersion(63); length != 17 {
+		t.Fatalf("ProtocolLengthForVersion(63) = %d, want 17", length)
+	}
+	if length := ProtocolLengthForVersion(62); length != 8 {
+		t.Fatalf("ProtocolLengthForVersion(62) = %d, want 8", length)
+	}
+	code := uint64(GetNodeDataMsg)
+	if code >= ProtocolLengthForVersion(63) {
+		t.Fatalf("GetNodeDataMsg (%d) should be within eth/63's length (%d)", code, ProtocolLengthForVersion(63))
+	}
+	if code < ProtocolLengthForVersion(62) {
+		t.Fatalf("GetNodeDataMsg (%d) unexpectedly within eth/62's length (%d)", code, ProtocolLengthForVersion(62))
+	}
+	if length := ProtocolLengthForVersion(99); length != 0 {
+		t.Fatalf("ProtocolLengthForVersion(99) = %d, want 0 for an unsupported version", length)
+	}
+}
+
+// TestSetHeadNilTD guards against a buggy caller passing a nil TD through to
+// SetHead - it should be treated as zero rather than panicking in
+// td.Cmp/p.td.Set.
+func TestSetHeadNilTD(t *testing.T) {
+	p := newTestPeerWithID(discover.NodeID{1})
+
+	p.SetHead(common.Hash{1}, nil)
+
+	_, td := p.Head()
+	if td == nil || td.Sign() != 0 {
+		t.Fatalf("SetHead(nil): got td %v, want 0", td)
+	}
+}
@@ -0,0 +1,83 @@
+package ethpeer
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter gates outgoing messages to a peer. Allow reports whether a
+// message may be sent right now; when it returns false the caller decides
+// whether to block and retry or drop the message. It's an interface so
+// tests can inject a fake and simulate bursts without waiting on a real
+// clock.
+type RateLimiter interface {
+	Allow() bool
+}
+
+// TokenBucket is a simple token-bucket RateLimiter: it holds up to
+// burst tokens, refilled at ratePerSec tokens per second.
+type TokenBucket struct {
+	mu         sync.Mutex
+	rate       float64
+	burst      float64
+	tokens     float64
+	lastRefill time.Time
+}
+
+// NewTokenBucket creates a bucket allowing ratePerSec messages per second on
+// average, with bursts up to burst messages.
+func NewTokenBucket(ratePerSec float64, burst int) *TokenBucket {
+	return &TokenBucket{
+		rate:       ratePerSec,
+		burst:      float64(burst),
+		tokens:     float64(burst),
+		lastRefill: time.Now(),
+	}
+}
+
+// Allow consumes a token if one is available.
+func (b *TokenBucket) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	elapsed := now.Sub(b.lastRefill).Seconds()
+	b.lastRefill = now
+
+	b.tokens += elapsed * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// SetRateLimiter installs a rate limiter guarding SendNewBlock and
+// SendTransactions on this peer. Pass nil to disable limiting (the
+// default). blockOnLimit controls whether Send* wait for a token to free up
+// (true) or silently drop the message (false) when the bucket is empty.
+func (p *Peer) SetRateLimiter(rl RateLimiter, blockOnLimit bool) {
+	p.limiter = rl
+	p.blockOnLimit = blockOnLimit
+}
+
+// awaitSendSlot returns nil once a message may be sent, and
+// errRateLimited if the message should be dropped instead.
+func (p *Peer) awaitSendSlot() error {
+	if p.limiter == nil {
+		return nil
+	}
+	if p.limiter.Allow() {
+		return nil
+	}
+	if !p.blockOnLimit {
+		return errRateLimited
+	}
+	for !p.limiter.Allow() {
+		time.Sleep(time.Millisecond)
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+package ethpeer
+
+import "testing"
+
+func TestTokenBucketBurst(t *testing.T) {
+	b := NewTokenBucket(1, 2)
+
+	if !b.Allow() || !b.Allow() {
+		t.Fatal("expected burst of 2 tokens to be allowed immediately")
+	}
+	if b.Allow() {
+		t.Fatal("expected bucket to be empty after burst is consumed")
+	}
+}
@@ -0,0 +1,52 @@
+package ethpeer
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/p2p"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	var buf bytes.Buffer
+	recorder := NewMsgRecorder(&buf)
+
+	sent := []p2p.Msg{
+		{Code: StatusMsg, Size: 3, Payload: bytes.NewReader([]byte("foo"))},
+		{Code: TxMsg, Size: 0, Payload: bytes.NewReader(nil)},
+		{Code: NewBlockMsg, Size: 5, Payload: bytes.NewReader([]byte("hello"))},
+	}
+	for i := range sent {
+		if err := recorder.RecordMsg(&sent[i]); err != nil {
+			t.Fatalf("RecordMsg(%d): %v", i, err)
+		}
+	}
+
+	replayer := NewMsgReplayer(&buf)
+	for i, want := range sent {
+		got, err := replayer.ReadMsg()
+		if err != nil {
+			t.Fatalf("ReadMsg(%d): %v", i, err)
+		}
+		if got.Code != want.Code || got.Size != want.Size {
+			t.Fatalf("ReadMsg(%d) = code %d size %d, want code %d size %d", i, got.Code, got.Size, want.Code, want.Size)
+		}
+		gotPayload, err := ioutil.ReadAll(got.Payload)
+		if err != nil {
+			t.Fatalf("read payload(%d): %v", i, err)
+		}
+		wantPayload, err := ioutil.ReadAll(want.Payload)
+		if err != nil {
+			t.Fatalf("read want payload(%d): %v", i, err)
+		}
+		if !bytes.Equal(gotPayload, wantPayload) {
+			t.Fatalf("ReadMsg(%d) payload = %q, want %q", i, gotPayload, wantPayload)
+		}
+	}
+
+	if _, err := replayer.ReadMsg(); err != io.EOF {
+		t.Fatalf("ReadMsg after exhausting recording = %v, want io.EOF", err)
+	}
+}
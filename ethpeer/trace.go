@@ -0,0 +1,84 @@
+package ethpeer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p"
+
+	"../logger"
+)
+
+// MsgTracer logs every inbound and outbound p2p message's metadata (peer id,
+// msg code, size) through the logger package's rolling file. It never logs
+// full payloads unless HexDump is set, since those can be large and are
+// usually noise.
+type MsgTracer struct {
+	Enabled bool
+	HexDump bool
+}
+
+// NewMsgTracer builds a MsgTracer from Config-level settings.
+func NewMsgTracer(enabled, hexDump bool) *MsgTracer {
+	return &MsgTracer{Enabled: enabled, HexDump: hexDump}
+}
+
+// Wrap returns rw unchanged if tracing is disabled, otherwise a
+// p2p.MsgReadWriter that logs every message read from or written to it
+// before passing it through.
+func (t *MsgTracer) Wrap(peerID string, rw p2p.MsgReadWriter) p2p.MsgReadWriter {
+	if t == nil || !t.Enabled {
+		return rw
+	}
+	return &tracingMsgReadWriter{rw: rw, peerID: peerID, tracer: t}
+}
+
+type tracingMsgReadWriter struct {
+	rw     p2p.MsgReadWriter
+	peerID string
+	tracer *MsgTracer
+}
+
+func (t *tracingMsgReadWriter) ReadMsg() (p2p.Msg, error) {
+	msg, err := t.rw.ReadMsg()
+	if err != nil {
+		return msg, err
+	}
+	t.tracer.trace("in", t.peerID, msg.Code, msg.Size, &msg)
+	return msg, nil
+}
+
+func (t *tracingMsgReadWriter) WriteMsg(msg p2p.Msg) error {
+	// Hex dumping an outbound message would consume its Payload before the
+	// real write does, so snapshot it first when requested.
+	if t.tracer.HexDump {
+		t.tracer.trace("out", t.peerID, msg.Code, msg.Size, &msg)
+	}
+	err := t.rw.WriteMsg(msg)
+	if err == nil && !t.tracer.HexDump {
+		t.tracer.trace("out", t.peerID, msg.Code, msg.Size, nil)
+	}
+	return err
+}
+
+// trace logs the message's metadata, and its hex-dumped payload when
+// HexDump is set. Passing msg lets us hex dump it; since that drains
+// msg.Payload, msg.Payload is replaced with a fresh reader over the same
+// bytes so the real read/write can still consume it afterwards.
+func (t *MsgTracer) trace(direction, peerID string, code uint64, size uint32, msg *p2p.Msg) {
+	if !t.HexDump || msg == nil {
+		logger.Info("msgtrace:", time.Now().Format("2006-01-02 15:04:05.000"), " ", direction, " peer=", peerID, " code=", code, " size=", size)
+		return
+	}
+
+	data, err := ioutil.ReadAll(msg.Payload)
+	if err != nil {
+		logger.Error("msgtrace: hex dump read err: ", err)
+		return
+	}
+	msg.Payload = bytes.NewReader(data)
+
+	logger.Info("msgtrace:", time.Now().Format("2006-01-02 15:04:05.000"), " ", direction, " peer=", peerID, " code=", code, " size=", size, " payload=", hex.EncodeToString(data))
+}
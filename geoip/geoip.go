@@ -0,0 +1,68 @@
+// Package geoip resolves country codes and ASNs for IP addresses from local
+// MaxMind GeoLite2 databases, for tagging crawled nodes with a rough
+// network topology (see redis.RedisClient.SetGeoLookup).
+package geoip
+
+import (
+	"net"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// Lookup resolves country/ASN from a pair of GeoLite2 databases. It
+// implements redis.GeoLookup.
+type Lookup struct {
+	countryDB *geoip2.Reader
+	asnDB     *geoip2.Reader
+}
+
+// Open loads the GeoLite2-Country database at countryDBPath and, if
+// asnDBPath is non-empty, the GeoLite2-ASN database too. Either path may be
+// absent - Open returns an error rather than panicking, so callers can skip
+// enrichment gracefully when the databases aren't installed.
+func Open(countryDBPath, asnDBPath string) (*Lookup, error) {
+	countryDB, err := geoip2.Open(countryDBPath)
+	if err != nil {
+		return nil, err
+	}
+	l := &Lookup{countryDB: countryDB}
+	if asnDBPath != "" {
+		asnDB, err := geoip2.Open(asnDBPath)
+		if err != nil {
+			countryDB.Close()
+			return nil, err
+		}
+		l.asnDB = asnDB
+	}
+	return l, nil
+}
+
+// Country returns the ISO country code for ip, e.g. "US".
+func (l *Lookup) Country(ip net.IP) (string, error) {
+	record, err := l.countryDB.Country(ip)
+	if err != nil {
+		return "", err
+	}
+	return record.Country.IsoCode, nil
+}
+
+// ASN returns the autonomous system number announcing ip, or 0 if the ASN
+// database wasn't configured.
+func (l *Lookup) ASN(ip net.IP) (uint, error) {
+	if l.asnDB == nil {
+		return 0, nil
+	}
+	record, err := l.asnDB.ASN(ip)
+	if err != nil {
+		return 0, err
+	}
+	return record.AutonomousSystemNumber, nil
+}
+
+// Close releases both underlying database files.
+func (l *Lookup) Close() {
+	l.countryDB.Close()
+	if l.asnDB != nil {
+		l.asnDB.Close()
+	}
+}
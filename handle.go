@@ -6,15 +6,20 @@ import (
 	// "encoding/hex"
 	"./logger"
 	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/eth"
 	"github.com/ethereum/go-ethereum/p2p"
 	// "github.com/ethereum/go-ethereum/p2p/discover"
 	"github.com/ethereum/go-ethereum/rlp"
-	// "io"
+	"io/ioutil"
 	// "github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/types"
 	// "github.com/ethereum/go-ethereum/params"
 	ethpeer "./ethpeer"
+	"net"
+	"sync/atomic"
+	"time"
 )
 
 func (pxy *proxy) handleStatus(p *p2p.Peer, msg p2p.Msg, rw p2p.MsgReadWriter) (err error) {
@@ -30,18 +35,49 @@ func (pxy *proxy) handleStatus(p *p2p.Peer, msg p2p.Msg, rw p2p.MsgReadWriter) (
 	pxy.upstreamConn[p.ID()] = &conn{p, rw}
 	// pxy.allPeer[p.ID()] = p
 	// NewPeer(version int, p *p2p.Peer, rw p2p.MsgReadWriter)
-	pp := ethpeer.NewPeer(myMessage.ProtocolVersion, p, rw)
+	pp := ethpeer.NewPeerWithLimits(myMessage.ProtocolVersion, p, rw, false, cfg.MaxKnownBlocks, cfg.MaxKnownTxs)
 	err = pxy.ethpeerset.Register(pp)
 	if err != nil {
 		// fmt.Println("pxy.ethpeerset.Register(pp):",err)
+	} else {
+		metricPeerCount.Inc()
+		pxy.recordNodeDiscovered(p.ID().String())
 	}
-	ethpeer := pxy.ethpeerset.Peer(p.ID().String())
-	if ethpeer != nil {
-		ethpeer.SetHead(myMessage.CurrentBlock, myMessage.TD)
-		ethpeer.SetGenesis(myMessage.GenesisBlock)
+	registeredPeer := pxy.ethpeerset.Peer(p.ID().String())
+	if registeredPeer != nil {
+		registeredPeer.SetHead(myMessage.CurrentBlock, myMessage.TD)
+		registeredPeer.SetGenesis(myMessage.GenesisBlock)
+		registeredPeer.SetAcceptForeignGenesis(cfg.AcceptForeignGenesis)
+		// Seed reputation from whatever this node ID last earned before a
+		// restart, rather than starting every peer back at 0.
+		registeredPeer.Adjust(red.GetPeerScore(p.ID().String()))
 	}
 
 	pxy.lock.Unlock()
+
+	if host, _, err := net.SplitHostPort(p.RemoteAddr().String()); err == nil {
+		pxy.recordNodeIP(p.ID().String(), host)
+	}
+
+	// TD/head/genesis are already known from myMessage, the StatusMsg
+	// handle() just decoded above - there's no need for a second handshake
+	// round trip to learn them. A separate ep.Handshake call here used to run
+	// concurrently with handle()'s own read loop on the very same rw: it sent
+	// the peer an unsolicited second StatusMsg (a protocol violation to real
+	// eth clients) and raced handle() to call rw.ReadMsg(), corrupting
+	// message delivery. So the genesis check that Handshake would have done
+	// is inlined here instead, against the StatusMsg already in hand.
+	if registeredPeer != nil && myMessage.GenesisBlock != genesis {
+		if cfg.AcceptForeignGenesis {
+			logger.Info("peer genesis mismatch, observing only:", registeredPeer.String(), ":", myMessage.GenesisBlock.Hex())
+		} else {
+			logger.Error("peer genesis mismatch:", registeredPeer.String(), ":", myMessage.GenesisBlock.Hex(), "!=", genesis.Hex())
+			pxy.ethpeerset.Unregister(p.ID().String())
+			metricPeerCount.Dec()
+			registeredPeer.Disconnect(p2p.DiscProtocolError)
+			return fmt.Errorf("genesis mismatch: %x (!= %x)", myMessage.GenesisBlock[:8], genesis[:8])
+		}
+	}
 	logger.Info("add:", p.ID())
 	// 	pxy.bestState = statusData{
 	// 		ProtocolVersion: myMessage.ProtocolVersion,
@@ -70,6 +106,7 @@ func (pxy *proxy) handleStatus(p *p2p.Peer, msg p2p.Msg, rw p2p.MsgReadWriter) (
 		CurrentBlock:    myMessage.CurrentBlock,
 		// GenesisBlock:    myMessage.GenesisBlock,
 		GenesisBlock: myMessage.GenesisBlock,
+		ForkID:       myMessage.ForkID,
 
 		// GenesisBlock:genesis,
 		// ProtocolVersion: pxy.bestState.ProtocolVersion,
@@ -113,6 +150,32 @@ func (pxy *proxy) handleNewBlockMsg(p *p2p.Peer, msg p2p.Msg) (err error) {
 	}
 	// fmt.Println("NewBlockMsg xx:", myMessage.Block.Number(), " from ", p.RemoteAddr().String())
 
+	if cfg.EnableBlockSanityChecks {
+		knownParent := pxy.BestHeader()
+		if err := checkBlockSanity(myMessage.Block.Header(), &knownParent, time.Now()); err != nil {
+			atomic.AddInt64(&pxy.blockSanityRejects, 1)
+			pxy.adjustPeerScore(p.ID().String(), scoreInvalidBlock)
+			logger.Error("NewBlockMsg: failed sanity check, rejecting:", myMessage.Block.Number().Text(10), " from:", p.RemoteAddr().String(), " err:", err)
+			return nil
+		}
+	}
+
+	pxy.propagation.Observe(myMessage.Block.Hash(), time.Now())
+
+	if pxy.newBlockDedupe.Seen(myMessage.Block.Hash()) {
+		logger.Info("NewBlockMsg: duplicate, skipping:", myMessage.Block.Number().Text(10), " from:", p.RemoteAddr().String())
+		return nil
+	}
+	pxy.adjustPeerScore(p.ID().String(), scoreValidBlock)
+
+	if registeredPeer := pxy.ethpeerset.Peer(p.ID().String()); registeredPeer != nil {
+		registeredPeer.MarkBlock(myMessage.Block.Hash())
+		if _, currentTD := registeredPeer.Head(); currentTD == nil || myMessage.TD.Cmp(currentTD) > 0 {
+			registeredPeer.SetHead(myMessage.Block.Hash(), myMessage.TD)
+		}
+		registeredPeer.SetHeadNumber(myMessage.Block.NumberU64())
+	}
+
 	{
 		// pxy.lock.Lock()
 		// defer pxy.lock.Unlock()
@@ -140,7 +203,7 @@ func (pxy *proxy) handleNewBlockMsg(p *p2p.Peer, msg p2p.Msg) (err error) {
 		// pxy.lock.Unlock()
 	}
 	// myMessage.Block=pxy.bestHeiAndPeer.bestHei
-	myMessage.TD = pxy.bestState.TD
+	myMessage.TD = pxy.BestState().TD
 	// need to re-encode msg
 	size, r, err := rlp.EncodeToReader(myMessage)
 	if err != nil {
@@ -152,9 +215,15 @@ func (pxy *proxy) handleNewBlockMsg(p *p2p.Peer, msg p2p.Msg) (err error) {
 }
 func (pxy *proxy) handleBlockHeadersMsg(p *p2p.Peer, msg p2p.Msg) (err error) {
 	fmt.Println("have BlockHeadersMsg")
-	var headers []*types.Header
-	if err := msg.Decode(&headers); err != nil {
+	raw, err := ioutil.ReadAll(msg.Payload)
+	if err != nil {
+		fmt.Println("handleBlockHeadersMsg: reading payload:", err)
+		return err
+	}
+	headers := acquireHeaderSlice()
+	if err := rlp.DecodeBytes(raw, &headers); err != nil {
 		fmt.Println("handleBlockHeadersMsg:", err)
+		releaseHeaderSlice(headers)
 		return err
 	}
 	// If no headers were received, but we're expending a DAO fork check, maybe it's that
@@ -183,9 +252,143 @@ func (pxy *proxy) handleBlockHeadersMsg(p *p2p.Peer, msg p2p.Msg) (err error) {
 	// 		return nil
 	// 	}
 	// }
+	for _, h := range headers {
+		pxy.headerSource.Add(h)
+	}
+	if peer := pxy.ethpeerset.Peer(p.ID().String()); peer != nil {
+		peer.RecordPong()
+	}
+	pxy.adjustPeerScore(p.ID().String(), scoreResponsive)
+	pxy.relayBlockHeadersRLP(p.ID().String(), raw)
 	pxy.bestHeaderChan <- headers
 	return nil
 }
+
+// relayBlockHeadersRLP forwards an already RLP encoded BlockHeadersMsg
+// reply from fromID on to every other connected peer via
+// ethpeer.Peer.SendBlockHeadersRLP, skipping the decode/re-encode round
+// trip a full types.Header re-marshal would otherwise cost per relay.
+func (pxy *proxy) relayBlockHeadersRLP(fromID string, raw rlp.RawValue) {
+	for id, peer := range pxy.ethpeerset.AllPeer() {
+		if id == fromID {
+			continue
+		}
+		if err := peer.SendBlockHeadersRLP(raw); err != nil {
+			logger.Error("relayBlockHeadersRLP: forwarding to ", id, " err: ", err)
+		}
+	}
+}
+func (pxy *proxy) handleTxMsg(p *p2p.Peer, msg p2p.Msg) (err error) {
+	var txs types.Transactions
+	if err := msg.Decode(&txs); err != nil {
+		logger.Error("decoding TxMsg err: ", err)
+		return err
+	}
+	logger.Info("TxMsg:", len(txs), " from:", p.RemoteAddr().String())
+
+	pxy.txStatsLock.Lock()
+	for _, tx := range txs {
+		if pxy.seenTx != nil && pxy.seenTx.Seen(tx.Hash()) {
+			continue
+		}
+		sender, err := types.Sender(pxy.txSigner, tx)
+		if err != nil {
+			pxy.txUnrecoverable++
+			continue
+		}
+		pxy.txSenderCounts[sender]++
+	}
+	pxy.txStatsLock.Unlock()
+
+	if cfg.RelayTxs {
+		pxy.relayTxs(p.ID().String(), txs)
+	}
+
+	return nil
+}
+
+// relayTxs re-broadcasts txs received from fromID to peers that don't yet
+// have them, capped the same way block propagation is (ethpeer.PropagationFanout
+// via cfg.PropagationFanout), turning the proxy into a mempool amplifier for
+// testing. Off by default via cfg.RelayTxs.
+func (pxy *proxy) relayTxs(fromID string, txs types.Transactions) {
+	for _, tx := range txs {
+		targets := pxy.ethpeerset.PeersWithoutTx(tx.Hash())
+		max := cfg.PropagationFanout
+		if max <= 0 {
+			max = ethpeer.PropagationFanout(len(targets))
+		}
+		sent := 0
+		for _, peer := range targets {
+			if peer.P.ID().String() == fromID {
+				continue
+			}
+			if sent >= max {
+				break
+			}
+			if err := peer.SendTransactions(types.Transactions{tx}); err != nil {
+				logger.Error("relayTxs: forwarding to ", peer.P.ID().String(), " err: ", err)
+				continue
+			}
+			sent++
+		}
+	}
+}
+
+// handleNewPooledTransactionHashesMsg observes eth/65 mempool gossip: peers
+// announce transaction hashes and let us decide whether to fetch the full
+// body. As a passive crawler we only log the announcement, we never issue
+// the follow-up GetPooledTransactions.
+func (pxy *proxy) handleNewPooledTransactionHashesMsg(p *p2p.Peer, msg p2p.Msg) (err error) {
+	var hashes []common.Hash
+	if err := msg.Decode(&hashes); err != nil {
+		logger.Error("decoding NewPooledTransactionHashesMsg err: ", err)
+		return err
+	}
+	logger.Info("NewPooledTransactionHashesMsg:", len(hashes), " from:", p.RemoteAddr().String())
+	return nil
+}
+
+// handleNodeDataMsg decodes a NodeDataMsg reply to Peer.RequestNodeData.
+// Each returned blob is keyed by its own keccak256 hash; blobs whose hash
+// doesn't match anything we asked for are discarded, since a peer could
+// otherwise feed us unrelated data under a mismatched key.
+func (pxy *proxy) handleNodeDataMsg(p *p2p.Peer, msg p2p.Msg) (err error) {
+	var data [][]byte
+	if err := msg.Decode(&data); err != nil {
+		logger.Error("decoding NodeDataMsg err: ", err)
+		return err
+	}
+	pxy.nodeDataLock.Lock()
+	kept := 0
+	for _, blob := range data {
+		hash := crypto.Keccak256Hash(blob)
+		if !pxy.pendingNodeData[hash] {
+			continue
+		}
+		pxy.nodeData[hash] = blob
+		delete(pxy.pendingNodeData, hash)
+		kept++
+	}
+	pxy.nodeDataLock.Unlock()
+	logger.Info("NodeDataMsg:", kept, "/", len(data), " from:", p.RemoteAddr().String())
+	pxy.adjustPeerScore(p.ID().String(), scoreResponsive)
+	return nil
+}
+
+// handleReceiptsMsg decodes a ReceiptsMsg reply to Peer.RequestReceipts and
+// hands the receipts off for auditing.
+func (pxy *proxy) handleReceiptsMsg(p *p2p.Peer, msg p2p.Msg) (err error) {
+	var receipts [][]*types.Receipt
+	if err := msg.Decode(&receipts); err != nil {
+		logger.Error("decoding ReceiptsMsg err: ", err)
+		return err
+	}
+	logger.Info("ReceiptsMsg:", len(receipts), " from:", p.RemoteAddr().String())
+	pxy.adjustPeerScore(p.ID().String(), scoreResponsive)
+	return nil
+}
+
 func (pxy *proxy) handleNewBlockHashesMsg(p *p2p.Peer, msg p2p.Msg) (err error) {
 	// fmt.Println("NewBlockHashesMsg")
 	// pxy.lock.Lock()
@@ -205,20 +408,26 @@ func (pxy *proxy) handleNewBlockHashesMsg(p *p2p.Peer, msg p2p.Msg) (err error)
 		logger.Error("decoding NewBlockHashesMsg err: ", err)
 		return err
 	}
-	// Mark the hashes as present at the remote node
-
-	{
-		// pxy.lock.Lock()
+	// Mark the hashes as present at the remote node so we don't re-propagate
+	// them, and track the highest announced block number.
+	if len(announces) > 0 {
+		ethpeer := pxy.ethpeerset.Peer(p.ID().String())
+		var highest uint64
 		for _, block := range announces {
-			// fmt.Println("NewBlockHashesMsg xx:", block.Number, " p:", p.RemoteAddr().String(), " Caps:", p.Caps())
-			// if block.Number > pxy.bestHei {
-			// 	fmt.Println("NewBlockHashesMsg:", block.Number, " p:", p.RemoteAddr().String(), " Caps:", p.Caps())
-			// 	pxy.bestHei = block.Number
-			// }
-			pxy.bestHeiChan2 <- bestHeiPeer{block.Number, p}
-			// fmt.Println("NewBlockHashesMsg:", block.Number, " from:", p)
+			if ethpeer != nil {
+				ethpeer.MarkBlock(block.Hash)
+				ethpeer.SetHeadNumber(block.Number)
+			}
+			pxy.propagation.Observe(block.Hash, time.Now())
+			if pxy.reorgDetector.Observe(p.ID().String(), block.Number, block.Hash) {
+				logger.WarnKV("handleNewBlockHashesMsg: reorg detected",
+					logger.F("peer", p.ID().String()), logger.F("height", block.Number), logger.F("hash", block.Hash.Hex()))
+			}
+			if block.Number > highest {
+				highest = block.Number
+			}
 		}
-		// pxy.lock.Unlock()
+		pxy.bestHeiChan2 <- bestHeiPeer{bestHei: highest, p: p}
 	}
 	// announces.Block=pxy.bestHeiAndPeer.bestHei
 	// announces.TD=pxy.bestState.TD
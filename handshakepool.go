@@ -0,0 +1,67 @@
+package main
+
+import "sync/atomic"
+
+// defaultHandshakeWorkers is how many eager handshakes (see handleStatus)
+// run concurrently when cfg.HandshakeWorkers isn't set.
+const defaultHandshakeWorkers = 32
+
+// defaultHandshakeQueueSize is how many handshakes may be queued waiting for
+// a free worker when cfg.HandshakeQueueSize isn't set.
+const defaultHandshakeQueueSize = 1024
+
+// handshakeWorkers lets seeker.toml override the handshake worker pool size.
+func handshakeWorkers() int {
+	if cfg.HandshakeWorkers != 0 {
+		return cfg.HandshakeWorkers
+	}
+	return defaultHandshakeWorkers
+}
+
+// handshakeQueueSize lets seeker.toml override the handshake queue depth.
+func handshakeQueueSize() int {
+	if cfg.HandshakeQueueSize != 0 {
+		return cfg.HandshakeQueueSize
+	}
+	return defaultHandshakeQueueSize
+}
+
+// handshakePool serializes handshake execution across a fixed set of
+// workers, so a burst of thousands of simultaneous connections at bootstrap
+// queues up behind a bounded channel instead of spawning one goroutine per
+// peer and swamping the scheduler. Submit blocks once the queue is full,
+// applying backpressure straight back to the caller.
+type handshakePool struct {
+	jobs  chan func()
+	depth int64 // number of jobs currently queued or running, for QueueDepth
+}
+
+// newHandshakePool starts a handshakePool with workers goroutines pulling
+// from a queue of the given depth.
+func newHandshakePool(workers, queueSize int) *handshakePool {
+	hp := &handshakePool{jobs: make(chan func(), queueSize)}
+	for i := 0; i < workers; i++ {
+		go hp.worker()
+	}
+	return hp
+}
+
+func (hp *handshakePool) worker() {
+	for job := range hp.jobs {
+		job()
+		metricHandshakeQueueDepth.Set(float64(atomic.AddInt64(&hp.depth, -1)))
+	}
+}
+
+// Submit enqueues fn to run on a pool worker, blocking if the queue is full.
+func (hp *handshakePool) Submit(fn func()) {
+	metricHandshakeQueueDepth.Set(float64(atomic.AddInt64(&hp.depth, 1)))
+	hp.jobs <- fn
+}
+
+// QueueDepth returns the number of handshakes currently queued or running,
+// exposed as a metric so a growing backlog during a bootstrap burst is
+// visible instead of hidden inside goroutines.
+func (hp *handshakePool) QueueDepth() int64 {
+	return atomic.LoadInt64(&hp.depth)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// headerBatchSizeHint sizes a freshly pooled slice's initial capacity to a
+// typical BlockHeadersMsg batch, so a decode on a pool miss doesn't
+// immediately need to grow the backing array.
+const headerBatchSizeHint = 192
+
+// headerSlicePool recycles the []*types.Header slices decoded from
+// BlockHeadersMsg. Header decoding is a hotspot during range sync, where a
+// single sync can pull millions of headers, so reusing the backing array
+// across messages avoids allocating a fresh slice per message.
+var headerSlicePool = sync.Pool{
+	New: func() interface{} {
+		s := make([]*types.Header, 0, headerBatchSizeHint)
+		return &s
+	},
+}
+
+// acquireHeaderSlice returns a pooled []*types.Header truncated to length 0,
+// ready to decode a BlockHeadersMsg into.
+func acquireHeaderSlice() []*types.Header {
+	s := headerSlicePool.Get().(*[]*types.Header)
+	return (*s)[:0]
+}
+
+// releaseHeaderSlice returns headers to the pool for reuse. Callers must not
+// read or write headers, or any alias of it, after calling this - the
+// *types.Header values it pointed to are unaffected, only the backing slice
+// is recycled.
+func releaseHeaderSlice(headers []*types.Header) {
+	headers = headers[:0]
+	headerSlicePool.Put(&headers)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// benchHeaders builds a BlockHeadersMsg-sized batch of headers with the
+// fields RLP needs non-nil (Number, Difficulty), matching what a real
+// GetBlockHeaders reply looks like during range sync.
+func benchHeaders(n int) []*types.Header {
+	headers := make([]*types.Header, n)
+	for i := 0; i < n; i++ {
+		headers[i] = &types.Header{
+			Number:     big.NewInt(int64(i)),
+			Difficulty: big.NewInt(1),
+			GasLimit:   8000000,
+			Time:       uint64(i),
+		}
+	}
+	return headers
+}
+
+func encodedHeaderPayload(tb testing.TB, headers []*types.Header) []byte {
+	data, err := rlp.EncodeToBytes(headers)
+	if err != nil {
+		tb.Fatalf("rlp.EncodeToBytes: %v", err)
+	}
+	return data
+}
+
+// BenchmarkDecodeBlockHeadersMsgFreshSlice decodes a BlockHeadersMsg-sized
+// payload into a freshly allocated slice each time, the behavior before
+// acquireHeaderSlice/releaseHeaderSlice were added.
+func BenchmarkDecodeBlockHeadersMsgFreshSlice(b *testing.B) {
+	data := encodedHeaderPayload(b, benchHeaders(headerBatchSizeHint))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var headers []*types.Header
+		if err := rlp.Decode(bytes.NewReader(data), &headers); err != nil {
+			b.Fatalf("rlp.Decode: %v", err)
+		}
+	}
+}
+
+// BenchmarkDecodeBlockHeadersMsgPooled decodes the same payload into a slice
+// borrowed from headerSlicePool, released back at the end of each iteration -
+// the fast path used by handleBlockHeadersMsg. It should show far fewer
+// allocations per op once the pool is warmed up.
+func BenchmarkDecodeBlockHeadersMsgPooled(b *testing.B) {
+	data := encodedHeaderPayload(b, benchHeaders(headerBatchSizeHint))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		headers := acquireHeaderSlice()
+		if err := rlp.Decode(bytes.NewReader(data), &headers); err != nil {
+			b.Fatalf("rlp.Decode: %v", err)
+		}
+		releaseHeaderSlice(headers)
+	}
+}
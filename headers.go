@@ -0,0 +1,88 @@
+package main
+
+import (
+	"./logger"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/eth"
+	"github.com/ethereum/go-ethereum/p2p"
+	"sync"
+)
+
+// HeaderSource backs handleGetBlockHeadersMsg. It lets the header responder
+// be plugged into anything - an in-memory cache, a real blockchain, a mock
+// for tests - without handleGetBlockHeadersMsg knowing the difference.
+type HeaderSource interface {
+	GetHeader(hash common.Hash, number uint64) *types.Header
+}
+
+// memoryHeaderSource is a small in-memory HeaderSource, good enough to
+// answer GetBlockHeadersMsg for the handful of headers we've actually seen
+// come through bestHeaderChan. Add and GetHeader are called concurrently
+// from every peer's handle goroutine against the one shared pxy.headerSource
+// instance, so both are guarded by mu.
+type memoryHeaderSource struct {
+	mu       sync.RWMutex
+	byHash   map[common.Hash]*types.Header
+	byNumber map[uint64]*types.Header
+}
+
+func newMemoryHeaderSource() *memoryHeaderSource {
+	return &memoryHeaderSource{
+		byHash:   make(map[common.Hash]*types.Header),
+		byNumber: make(map[uint64]*types.Header),
+	}
+}
+
+func (s *memoryHeaderSource) Add(h *types.Header) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.byHash[h.Hash()] = h
+	s.byNumber[h.Number.Uint64()] = h
+}
+
+func (s *memoryHeaderSource) GetHeader(hash common.Hash, number uint64) *types.Header {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if h, ok := s.byHash[hash]; ok {
+		return h
+	}
+	return s.byNumber[number]
+}
+
+// hashOrNumber is the on-the-wire union used by GetBlockHeadersMsg to
+// identify the origin block, either by hash or by number.
+type hashOrNumber struct {
+	Hash   common.Hash
+	Number uint64
+}
+
+// getBlockHeadersData is the network packet for the header query message.
+type getBlockHeadersData struct {
+	Origin  hashOrNumber
+	Amount  uint64
+	Skip    uint64
+	Reverse bool
+}
+
+// handleGetBlockHeadersMsg answers a peer's header request from headerSource
+// when we can, and with an empty response otherwise, so unresponsive-peer
+// heuristics on the other end don't drop us.
+func (pxy *proxy) handleGetBlockHeadersMsg(p *p2p.Peer, msg p2p.Msg, rw p2p.MsgReadWriter) (err error) {
+	var query getBlockHeadersData
+	if err := msg.Decode(&query); err != nil {
+		logger.Error("decoding GetBlockHeadersMsg err: ", err)
+		return err
+	}
+
+	var headers []*types.Header
+	if h := pxy.headerSource.GetHeader(query.Origin.Hash, query.Origin.Number); h != nil {
+		headers = []*types.Header{h}
+	}
+
+	ethpeer := pxy.ethpeerset.Peer(p.ID().String())
+	if ethpeer != nil {
+		return ethpeer.SendBlockHeaders(headers)
+	}
+	return p2p.Send(rw, eth.BlockHeadersMsg, headers)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"./logger"
+	"github.com/ethereum/go-ethereum/p2p"
+)
+
+const defaultHeartbeatIntervalSeconds = 30
+
+func heartbeatInterval() int {
+	if cfg.HeartbeatIntervalSeconds != 0 {
+		return cfg.HeartbeatIntervalSeconds
+	}
+	return defaultHeartbeatIntervalSeconds
+}
+
+const defaultHeartbeatMaxMisses = 3
+
+func heartbeatMaxMisses() int {
+	if cfg.HeartbeatMaxMisses != 0 {
+		return cfg.HeartbeatMaxMisses
+	}
+	return defaultHeartbeatMaxMisses
+}
+
+// heartbeatPeers pings every connected peer with a lightweight
+// GetBlockHeaders request for its own reported head and measures the
+// round-trip time via Peer.RecordPong, giving RTT data for peer selection.
+// A peer that misses heartbeatMaxMisses probes in a row is dropped as
+// unresponsive rather than left occupying a peer slot.
+func (pxy *proxy) heartbeatPeers() {
+	maxMisses := heartbeatMaxMisses()
+	for _, p := range pxy.ethpeerset.AllPeer() {
+		if p.PingOutstanding() {
+			misses := p.MissHeartbeat()
+			logger.WarnKV("heartbeatPeers: peer missed heartbeat",
+				logger.F("peer", p.P.ID().String()), logger.F("misses", misses))
+			if misses >= maxMisses {
+				pxy.adjustPeerScore(p.P.ID().String(), scoreUnresponsive)
+				p.Disconnect(p2p.DiscUselessPeer)
+			}
+			continue
+		}
+		head, _ := p.Head()
+		if err := p.RequestHeadersByHash(head, 1, 0, false); err != nil {
+			logger.WarnKV("heartbeatPeers: sending probe failed",
+				logger.F("peer", p.P.ID().String()), logger.F("err", err))
+			continue
+		}
+		p.MarkPingSent()
+	}
+}
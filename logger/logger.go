@@ -225,6 +225,45 @@ func Fatal(v ...interface{}) {
 	}
 }
 
+// Field is a single structured key/value pair for InfoKV/ErrorKV, so logs
+// that carry things like a peer id or a total difficulty stay grep/parse
+// friendly instead of turning into free-form sentences.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F builds a Field, e.g. logger.F("peer", id).
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+func formatFields(fields []Field) string {
+	s := ""
+	for i, f := range fields {
+		if i > 0 {
+			s += " "
+		}
+		s += fmt.Sprintf("%s=%v", f.Key, f.Value)
+	}
+	return s
+}
+
+// InfoKV logs msg at INFO followed by fields formatted as key=value pairs.
+func InfoKV(msg string, fields ...Field) {
+	Info(msg, formatFields(fields))
+}
+
+// ErrorKV logs msg at ERROR followed by fields formatted as key=value pairs.
+func ErrorKV(msg string, fields ...Field) {
+	Error(msg, formatFields(fields))
+}
+
+// WarnKV logs msg at WARN followed by fields formatted as key=value pairs.
+func WarnKV(msg string, fields ...Field) {
+	Warn(msg, formatFields(fields))
+}
+
 func (f *_FILE) isMustRename() bool {
 	if dailyRolling {
 		t, _ := time.Parse(DATEFORMAT, time.Now().Format(DATEFORMAT))
@@ -2,9 +2,13 @@ package main
 
 import (
 	ethpeer "./ethpeer"
+	"./geoip"
 	"./logger"
+	"./nodestore"
 	util "./utils"
+	"context"
 	"crypto/ecdsa"
+	"flag"
 	"fmt"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
@@ -15,8 +19,12 @@ import (
 	"math/big"
 	// "net"
 	"net"
-	// "os"
+	"os"
+	"os/signal"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	// "github.com/ethereum/go-ethereum/cmd/utils"
@@ -31,12 +39,38 @@ import (
 
 var cfg *util.Config
 var red *redis.RedisClient
+var nodeStore nodestore.NodeStore
+
+// nodeWriteBuffer batches connectNode's discovery writes via
+// redis.NodeWriteBuffer when nodeStore is redis-backed, instead of paying
+// for one Multi/Exec round trip per discovered node. It stays nil for the
+// memory/file-backed stores, where there's no round trip to amortize.
+var nodeWriteBuffer *redis.NodeWriteBuffer
+
+var (
+	modeFlag   = flag.String("mode", "crawl", "run mode: crawl (write node ip to redis), discover (run only the discovery UDP listener), hack (crawl with hack-block injection forced on), selftest (connect two local instances and exit pass/fail)")
+	configFlag = flag.String("config", "seeker.toml", "path to the toml config file")
+)
 
 const prefix = "eth"
 
+// logUnit maps cfg.Log.Unit to logger.UNIT, defaulting to KB (the
+// previously hardcoded unit) when unset. util.Config.Validate already
+// rejects anything else.
+func logUnit() logger.UNIT {
+	switch cfg.Log.Unit {
+	case "MB":
+		return logger.MB
+	case "GB":
+		return logger.GB
+	default:
+		return logger.KB
+	}
+}
+
 func log_init() {
 	logger.SetConsole(cfg.Log.Console)
-	logger.SetRollingFile(cfg.Log.Dir, cfg.Log.Name, cfg.Log.Num, cfg.Log.Size, logger.KB)
+	logger.SetRollingFile(cfg.Log.Dir, cfg.Log.Name, cfg.Log.Num, cfg.Log.Size, logUnit())
 	//ALL，DEBUG，INFO，WARN，ERROR，FATAL，OFF
 	logger.SetLevel(logger.ERROR)
 	if cfg.Log.Level == "info" {
@@ -46,14 +80,57 @@ func log_init() {
 	}
 }
 func init() {
+	flag.Parse()
+
 	cfg = &util.Config{}
 
-	if !util.LoadConfig("seeker.toml", cfg) {
-		return
+	if err := util.LoadConfigStrict(*configFlag, cfg); err != nil {
+		fmt.Println("invalid config:", err)
+		os.Exit(1)
 	}
+	applyNetworkConfig(cfg)
 	log_init()
 	// initialize()
-	red = redis.NewRedisClient(&redis.Config{Endpoint: "127.0.0.1:6379", Password: "etcpool123", Database: 0, PoolSize: 10}, prefix)
+	red = redis.NewRedisClient(&redis.Config{
+		Endpoint: cfg.Redis.Endpoint,
+		Password: cfg.Redis.Password,
+		Database: cfg.Redis.Database,
+		PoolSize: cfg.Redis.PoolSize,
+	}, prefix)
+
+	if cfg.Store == "memory" {
+		nodeStore = nodestore.NewMemoryStore()
+	} else if err := red.HealthCheck(); err != nil {
+		logger.ErrorKV("init: redis unreachable, falling back to file-backed NodeStore",
+			logger.F("err", err), logger.F("file", cfg.NodeFallbackFile))
+		fileStore, ferr := nodestore.NewFileStore(cfg.NodeFallbackFile)
+		if ferr != nil {
+			logger.ErrorKV("init: failed to open fallback NodeStore file, discovery data will not persist",
+				logger.F("err", ferr))
+			nodeStore = nodestore.NewMemoryStore()
+		} else {
+			nodeStore = fileStore
+		}
+	} else {
+		nodeStore = redis.NewWriteCircuitBreaker(red, redis.CircuitBreakerConfig{
+			FailureThreshold: cfg.CircuitBreakerFailureThreshold,
+			Cooldown:         time.Duration(cfg.CircuitBreakerCooldownSeconds) * time.Second,
+			MaxBuffered:      cfg.CircuitBreakerMaxBuffered,
+		})
+		nodeWriteBuffer = redis.NewNodeWriteBuffer(red, 0, 0)
+	}
+
+	go red.WatchConnection(time.Duration(redisHealthCheckIntervalSeconds()) * time.Second)
+
+	if cfg.GeoIPCountryDBPath != "" {
+		if lookup, err := geoip.Open(cfg.GeoIPCountryDBPath, cfg.GeoIPASNDBPath); err != nil {
+			logger.ErrorKV("init: geoip database unavailable, disabling enrichment", logger.F("err", err))
+		} else {
+			red.SetGeoLookup(lookup)
+		}
+	}
+
+	forwardRules = buildForwardRules(cfg.ForwardRules)
 }
 
 const (
@@ -63,22 +140,156 @@ const (
 	// upstreamUrl = "enode://2998c333662a61620126e8a5a44545b8c0b362ec8a89b246a3e2e15a076983525e148ef113152d2836b976fb8de860b03f997012793870d78ae0a56e565d8398@118.31.112.214:30304" //getf1
 
 	listenAddr = "0.0.0.0:36666"
-	privkey    = ""
 	//设置初值
 	// 5294375 2881436154511909728
+	defaultMaxPeers = 300
+)
+
+// listenAddrOrDefault lets seeker.toml override the p2p listen address, so
+// multiple crawler instances can run on one host without editing source.
+func listenAddrOrDefault() string {
+	if cfg.ListenAddr != "" {
+		return cfg.ListenAddr
+	}
+	return listenAddr
+}
+
+// maxPeers lets seeker.toml override the p2p max peer count.
+func maxPeers() int {
+	if cfg.MaxPeers != 0 {
+		return cfg.MaxPeers
+	}
+	return defaultMaxPeers
+}
+
+// bootNodesOrDefault lets seeker.toml supply a private bootnode list, so
+// private networks can be crawled instead of only mainnet.
+func bootNodesOrDefault() []string {
+	if len(cfg.BootNodes) != 0 {
+		return cfg.BootNodes
+	}
+	return MainnetBootnodes
+}
+
+const defaultRedialConcurrency = 10
+
+// redialConcurrency lets seeker.toml override how many enodes are redialed
+// concurrently each tick.
+func redialConcurrency() int {
+	if cfg.RedialConcurrency != 0 {
+		return cfg.RedialConcurrency
+	}
+	return defaultRedialConcurrency
+}
+
+// redialFromRedis re-dials nodes discovered in previous runs, so the crawl
+// keeps growing instead of only ever knowing about the initial bootnodes.
+// Nodes are tried freshest-first via GetEnodesRanked so the limited
+// redialConcurrency() slots go to the dials most likely to succeed. Nodes
+// already tracked in pxy.ethpeerset are skipped, and dials are throttled to
+// redialConcurrency() at a time so we don't flood the server with
+// connection attempts.
+func (pxy *proxy) redialFromRedis() {
+	nodes := red.GetEnodesRanked()
+	sem := make(chan struct{}, redialConcurrency())
+	var wg sync.WaitGroup
+	for _, node := range nodes {
+		id := node.ID.String()
+		if pxy.ethpeerset.Peer(id) != nil {
+			continue
+		}
+		if !pxy.dialBackoff.Allow(id) {
+			continue
+		}
+		if pxy.blacklist.Contains(node.IP.String()) {
+			logger.WarnKV("redialFromRedis: skipping blacklisted IP", logger.F("ip", node.IP.String()), logger.F("id", id))
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(n *discover.Node) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			pxy.srv.AddPeer(n)
+
+			// AddPeer only schedules the dial; give it a moment to land in
+			// ethpeerset before deciding whether it succeeded.
+			time.Sleep(dialResultWait)
+			if pxy.ethpeerset.Peer(n.ID.String()) != nil {
+				pxy.dialBackoff.RecordSuccess(n.ID.String())
+			} else {
+				pxy.dialBackoff.RecordFailure(n.ID.String())
+			}
+		}(node)
+	}
+	wg.Wait()
+}
+
+// Defaults matching the network this tool originally shipped targeting,
+// used when the corresponding Config field is left unset so existing
+// seeker.toml files keep working unmodified.
+const (
+	defaultGenesisHash = "0xd4e56740f876aef8c010b86a40d5f56745a118d0906a34e69aec8c0db1cb8fa3"
+	defaultStartBlock  = "0xdc2d938e4cd0a149681e9e04352953ef5ab399d59bcd5b0357f6c0797470a524"
+	defaultStartTD     = "2303762395359969"
+	defaultProtocolVersion = uint32(63)
+	defaultNetworkID       = uint64(888888)
 )
 
 var (
-	// startBlock = common.StringToHash("0x58f3ea40c3d1ffdea3c88b8d77ede6bdc2ecd6dc88b24aa2479304c359a043e5")
-	// startTD    = big.NewInt(2881436154511909728)
-	// 换个低一些的高度10000
-	startBlock = common.HexToHash("0xdc2d938e4cd0a149681e9e04352953ef5ab399d59bcd5b0357f6c0797470a524")
-	startTD    = big.NewInt(2303762395359969)
-	genesis    = common.HexToHash("0xd4e56740f876aef8c010b86a40d5f56745a118d0906a34e69aec8c0db1cb8fa3")
-	gversion   = uint32(63)
-	gnetworkid = uint64(888888)
+	startBlock common.Hash
+	startTD    *big.Int
+	genesis    common.Hash
+	gversion   uint32
+	gnetworkid uint64
+	gchainID   uint64
 )
 
+// applyNetworkConfig populates the package-level network parameters from
+// cfg, so a single binary can target mainnet or any other chain from
+// seeker.toml instead of the hardcoded testnet this tool originally shipped
+// against. cfg.Validate already checked GenesisHash decodes to 32 bytes.
+// gchainID defaults to gnetworkid when cfg.ChainID is unset, which is
+// correct for most chains, since network ID and EIP-155 chain ID coincide
+// on nearly every network except the handful with a documented split.
+func applyNetworkConfig(cfg *util.Config) {
+	genesisHash := cfg.GenesisHash
+	if genesisHash == "" {
+		genesisHash = defaultGenesisHash
+	}
+	genesis = common.HexToHash(genesisHash)
+
+	startBlockHash := cfg.StartBlock
+	if startBlockHash == "" {
+		startBlockHash = defaultStartBlock
+	}
+	startBlock = common.HexToHash(startBlockHash)
+
+	tdStr := cfg.StartTD
+	if tdStr == "" {
+		tdStr = defaultStartTD
+	}
+	td, ok := new(big.Int).SetString(tdStr, 10)
+	if !ok {
+		td, _ = new(big.Int).SetString(defaultStartTD, 10)
+	}
+	startTD = td
+
+	gversion = cfg.ProtocolVersion
+	if gversion == 0 {
+		gversion = defaultProtocolVersion
+	}
+	gnetworkid = cfg.NetworkID
+	if gnetworkid == 0 {
+		gnetworkid = defaultNetworkID
+	}
+
+	gchainID = cfg.ChainID
+	if gchainID == 0 {
+		gchainID = gnetworkid
+	}
+}
+
 // statusData is the network packet for the status message.
 type statusData struct {
 	ProtocolVersion uint32
@@ -86,6 +297,7 @@ type statusData struct {
 	TD              *big.Int
 	CurrentBlock    common.Hash
 	GenesisBlock    common.Hash
+	ForkID          ethpeer.ForkID `rlp:"optional"` // eth/64+ only
 }
 
 func (s *statusData) String() string {
@@ -134,19 +346,315 @@ type proxy struct {
 	bestHeader      types.Header
 	bestHeaderChan  chan []*types.Header
 	hackChan        chan bool
+	headerSource    *memoryHeaderSource
+
+	txSigner        types.Signer
+	txStatsLock     sync.Mutex
+	txSenderCounts  map[common.Address]int
+	txUnrecoverable int64
+
+	nodeDataLock    sync.Mutex
+	pendingNodeData map[common.Hash]bool
+	nodeData        map[common.Hash][]byte
+
+	msgTracer *ethpeer.MsgTracer
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	newBlockDedupe *blockDedupe
+
+	propagation *propagationTracker
+
+	reorgDetector *reorgDetector
+
+	dialBackoff *dialBackoff
+
+	blacklist *ipBlacklist
+
+	staticNodes []*discover.Node
+
+	evictionPolicy PeerEvictionPolicy
+
+	// divergenceSince and eclipseDivergenceCount back checkEclipseDivergence.
+	divergenceSince        time.Time
+	eclipseDivergenceCount int64
+
+	// nodesDiscovered is the cumulative count of unique node IDs ever
+	// registered, persisted in redis via IncrNodesDiscovered so it keeps
+	// growing across restarts. discoveredNodeIDs guards against
+	// double-counting a node that disconnects and reconnects. See
+	// recordNodeDiscovered/NodesDiscovered.
+	nodesDiscovered   uint64
+	discoveredLock    sync.Mutex
+	discoveredNodeIDs map[string]bool
+
+	// forwardDedupe suppresses re-forwarding content ForwardRules has
+	// already relayed once, so a message can't bounce upstream/downstream
+	// forever. See forwardMsg in protocol.go.
+	forwardDedupe *blockDedupe
+
+	// blockSanityRejects counts NewBlockMsg headers dropped by
+	// checkBlockSanity (see blocksanity.go), when cfg.EnableBlockSanityChecks
+	// is set.
+	blockSanityRejects int64
+
+	// handshakes runs the eager per-peer handshake (see handleStatus) on a
+	// bounded worker pool instead of one goroutine per connection. See
+	// handshakepool.go.
+	handshakes *handshakePool
+
+	// seenTx dedups TxMsg transactions across restarts, when
+	// cfg.SeenTxFilterEnabled is set. nil (and unconsulted) otherwise. See
+	// seentxfilter.go.
+	seenTx *seenTxFilter
+}
+
+// BlockSanityRejects returns the running count of NewBlockMsg headers
+// checkBlockSanity has rejected.
+func (pxy *proxy) BlockSanityRejects() int64 {
+	return atomic.LoadInt64(&pxy.blockSanityRejects)
+}
+
+// NodesDiscovered returns the cumulative count of unique node IDs ever
+// registered with pxy.ethpeerset, unlike pxy.srv.PeerCount() which only
+// reflects currently connected peers.
+func (pxy *proxy) NodesDiscovered() uint64 {
+	return atomic.LoadUint64(&pxy.nodesDiscovered)
+}
+
+// recordNodeDiscovered marks id as seen and bumps NodesDiscovered (and its
+// redis-persisted counterpart) the first time, and only the first time, id
+// is registered.
+func (pxy *proxy) recordNodeDiscovered(id string) {
+	pxy.discoveredLock.Lock()
+	if pxy.discoveredNodeIDs[id] {
+		pxy.discoveredLock.Unlock()
+		return
+	}
+	pxy.discoveredNodeIDs[id] = true
+	pxy.discoveredLock.Unlock()
+
+	atomic.StoreUint64(&pxy.nodesDiscovered, red.IncrNodesDiscovered())
+}
+
+// RequestNodeData asks p for the given state trie nodes, recording the
+// hashes as pending so handleNodeDataMsg can verify the reply against what
+// we actually asked for.
+func (pxy *proxy) RequestNodeData(p *ethpeer.Peer, hashes []common.Hash) error {
+	pxy.nodeDataLock.Lock()
+	for _, h := range hashes {
+		pxy.pendingNodeData[h] = true
+	}
+	pxy.nodeDataLock.Unlock()
+	return p.RequestNodeData(hashes)
+}
+
+// NodeData returns a state trie node previously fetched via RequestNodeData,
+// or nil if it hasn't (yet) been received.
+func (pxy *proxy) NodeData(hash common.Hash) []byte {
+	pxy.nodeDataLock.Lock()
+	defer pxy.nodeDataLock.Unlock()
+	return pxy.nodeData[hash]
 }
 type bestHeiPeer struct {
 	bestHei uint64
 	p       *p2p.Peer
 }
 
+// watchStaticNodes re-adds any of pxy.staticNodes that have fallen out of
+// ethpeerset, so a dropped upstream connection gets re-dialed instead of
+// staying down until something else happens to reconnect it.
+func (pxy *proxy) watchStaticNodes() {
+	for _, n := range pxy.staticNodes {
+		if pxy.ethpeerset.Peer(n.ID.String()) != nil {
+			continue
+		}
+		logger.Info("watchStaticNodes: static node", n.ID.String(), "is disconnected, re-adding")
+		pxy.srv.AddPeer(n)
+
+		time.Sleep(dialResultWait)
+		if pxy.ethpeerset.Peer(n.ID.String()) != nil {
+			logger.Info("watchStaticNodes: reconnected static node", n.ID.String())
+		} else {
+			logger.Error("watchStaticNodes: failed to reconnect static node", n.ID.String())
+		}
+	}
+}
+
+// pruneStalledPeers logs, and if cfg.DisconnectStalledPeers is set,
+// disconnects every peer whose TD hasn't advanced in
+// cfg.StalledPeerWindowSeconds - a peer that handshook then stopped
+// reporting a higher TD while the rest of the crawl kept moving is most
+// likely wedged rather than genuinely caught up.
+func (pxy *proxy) pruneStalledPeers() {
+	if cfg.StalledPeerWindowSeconds <= 0 {
+		return
+	}
+	window := time.Duration(cfg.StalledPeerWindowSeconds) * time.Second
+	for _, p := range pxy.ethpeerset.StalledPeers(window) {
+		logger.WarnKV("pruneStalledPeers: peer head hasn't advanced",
+			logger.F("peer", p.P.ID().String()), logger.F("window", window))
+		pxy.adjustPeerScore(p.P.ID().String(), scoreStalled)
+		if cfg.DisconnectStalledPeers {
+			p.Disconnect(p2p.DiscUselessPeer)
+		}
+	}
+}
+
+// PeerEvictionPolicy selects which connected peer to disconnect to free a
+// slot when the peer set is near capacity. It's swappable via
+// proxy.evictionPolicy so a caller can plug in custom selection logic
+// instead of the lowestTDEvictionPolicy default.
+type PeerEvictionPolicy interface {
+	// SelectVictim returns the peer that should be disconnected to make
+	// room, or nil if none of the given peers should be evicted.
+	SelectVictim(peers []*ethpeer.Peer) *ethpeer.Peer
+}
+
+// lowestTDEvictionPolicy evicts the peer with the lowest total difficulty,
+// breaking ties by whichever has the lower reputation Score, and any
+// remaining tie by whichever has gone longest without a head update - a
+// low-TD, low-reputation, idle peer is the least valuable one to keep
+// occupying a slot.
+type lowestTDEvictionPolicy struct{}
+
+func (lowestTDEvictionPolicy) SelectVictim(peers []*ethpeer.Peer) *ethpeer.Peer {
+	var victim *ethpeer.Peer
+	var victimTD *big.Int
+	for _, p := range peers {
+		_, td := p.Head()
+		if td == nil {
+			td = big.NewInt(0)
+		}
+		switch {
+		case victim == nil:
+			victim, victimTD = p, td
+		case td.Cmp(victimTD) < 0:
+			victim, victimTD = p, td
+		case td.Cmp(victimTD) == 0 && p.Score() < victim.Score():
+			victim, victimTD = p, td
+		case td.Cmp(victimTD) == 0 && p.Score() == victim.Score() && p.IdleDuration() > victim.IdleDuration():
+			victim, victimTD = p, td
+		}
+	}
+	return victim
+}
+
+// evictionHeadroom is how many free slots pruneForCapacity tries to keep
+// available once the peer set nears maxPeers(), so a newly discovered
+// valuable peer isn't turned away by p2p.Server's own hard MaxPeers cap.
+const evictionHeadroom = 5
+
+// pruneForCapacity disconnects pxy.evictionPolicy's chosen victim once the
+// peer set is within evictionHeadroom of maxPeers(), making room for new
+// connections instead of leaving low-quality peers occupying every slot.
+func (pxy *proxy) pruneForCapacity() {
+	limit := maxPeers()
+	all := pxy.ethpeerset.AllPeer()
+	if len(all) < limit-evictionHeadroom {
+		return
+	}
+	peers := make([]*ethpeer.Peer, 0, len(all))
+	for _, p := range all {
+		peers = append(peers, p)
+	}
+	victim := pxy.evictionPolicy.SelectVictim(peers)
+	if victim == nil {
+		return
+	}
+	_, td := victim.Head()
+	logger.WarnKV("pruneForCapacity: evicting low-value peer to make room",
+		logger.F("peer", victim.P.ID().String()), logger.F("td", td), logger.F("idle", victim.IdleDuration()))
+	victim.Disconnect(p2p.DiscTooManyPeers)
+}
+
+// checkEclipseDivergence compares the peer population's best known head
+// height against the trusted upstream node's head height, warning (and
+// bumping eclipseDivergenceCount) once they differ by more than
+// cfg.EclipseDivergenceBlocks for at least
+// cfg.EclipseDivergenceWindowSeconds - a wide, sustained gap between the one
+// node we trust and the rest of the peer population is a sign of an eclipse
+// attack or network partition, not ordinary chain lag.
+func (pxy *proxy) checkEclipseDivergence() {
+	if cfg.EclipseDivergenceBlocks <= 0 || cfg.EclipseDivergenceWindowSeconds <= 0 {
+		return
+	}
+	trusted := pxy.ethpeerset.Peer(pxy.upstreamNode.ID.String())
+	best := pxy.ethpeerset.BestPeer()
+	if trusted == nil || best == nil {
+		return
+	}
+
+	diff := int64(best.HeadNumber()) - int64(trusted.HeadNumber())
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff <= int64(cfg.EclipseDivergenceBlocks) {
+		pxy.divergenceSince = time.Time{}
+		return
+	}
+	if pxy.divergenceSince.IsZero() {
+		pxy.divergenceSince = time.Now()
+		return
+	}
+	if time.Since(pxy.divergenceSince) < time.Duration(cfg.EclipseDivergenceWindowSeconds)*time.Second {
+		return
+	}
+	pxy.eclipseDivergenceCount++
+	logger.WarnKV("checkEclipseDivergence: peer population diverges from trusted node",
+		logger.F("trustedHeight", trusted.HeadNumber()), logger.F("bestHeight", best.HeadNumber()),
+		logger.F("diff", diff), logger.F("since", pxy.divergenceSince))
+}
+
+// BestState returns a copy of the proxy's current best status, safe to call
+// from any goroutine.
+func (pxy *proxy) BestState() statusData {
+	pxy.lock.RLock()
+	defer pxy.lock.RUnlock()
+	return pxy.bestState
+}
+
+// BestHeader returns a copy of the proxy's current best header, safe to call
+// from any goroutine.
+func (pxy *proxy) BestHeader() types.Header {
+	pxy.lock.RLock()
+	defer pxy.lock.RUnlock()
+	return pxy.bestHeader
+}
+
+// persistChainTip writes the proxy's current best block number, hash and TD
+// to Redis, so a restart can pick up from here via GetChainTip instead of
+// regressing to the hardcoded startBlock/startTD.
+func (pxy *proxy) persistChainTip() {
+	pxy.lock.RLock()
+	header, state := pxy.bestHeader, pxy.bestState
+	pxy.lock.RUnlock()
+
+	if header.Number == nil || state.TD == nil {
+		return
+	}
+	if err := red.WriteChainTip(header.Number.Uint64(), state.CurrentBlock, state.TD); err != nil {
+		logger.ErrorKV("persistChainTip: write failed", logger.F("err", err))
+	}
+}
+
 func (pxy *proxy) Start() {
 	tick := time.Tick(50000 * time.Millisecond)
 	tickPullBestBlock := time.Tick(10000 * time.Millisecond)
+	tickRedial := time.Tick(30000 * time.Millisecond)
+	tickStaticWatch := time.Tick(15000 * time.Millisecond)
+	tickStalledPeers := time.Tick(60000 * time.Millisecond)
+	tickEviction := time.Tick(30000 * time.Millisecond)
+	tickEclipseCheck := time.Tick(30000 * time.Millisecond)
+	tickHeartbeat := time.Tick(time.Duration(heartbeatInterval()) * time.Second)
 	pxy.hackChan <- true
 	go func() {
 		for {
 			select {
+			case <-pxy.ctx.Done():
+				logger.Info("Start: context canceled, stopping tickers")
+				return
 			case hei, ok := <-pxy.bestHeiChan:
 				if !ok {
 					break
@@ -165,19 +673,33 @@ func (pxy *proxy) Start() {
 				if !ok {
 					break
 				}
-				if beststate.TD.Cmp(pxy.bestState.TD) > 0 && beststate.GenesisBlock.Hex() == genesis.Hex() {
+				pxy.lock.Lock()
+				advanced := beststate.TD.Cmp(pxy.bestState.TD) > 0 && beststate.GenesisBlock.Hex() == genesis.Hex()
+				if advanced {
 					pxy.bestState = beststate
 				}
+				pxy.lock.Unlock()
+				if advanced {
+					pxy.persistChainTip()
+				}
 			case bestheaders, ok := <-pxy.bestHeaderChan:
 				// []*types.Header
 				if !ok {
 					break
 				}
 				for _, h := range bestheaders {
-					if h.Number.Cmp(pxy.bestHeader.Number) > 0 {
+					pxy.lock.Lock()
+					advanced := h.Number.Cmp(pxy.bestHeader.Number) > 0
+					if advanced {
 						pxy.bestHeader = *h
 					}
+					pxy.lock.Unlock()
+					if advanced {
+						observeBestHeight(float64(h.Number.Uint64()))
+						pxy.persistChainTip()
+					}
 				}
+				releaseHeaderSlice(bestheaders)
 			case <-tick:
 				// fmt.Println("newblockmsg besthei:", pxy.bestHeiAndPeer.bestHei, " from:", pxy.bestHeiAndPeer.p)
 				// fmt.Println("NewBlockHashesMsg besthei:", pxy.bestHeiAndPeer2.bestHei, " from:", pxy.bestHeiAndPeer2.p)
@@ -187,14 +709,59 @@ func (pxy *proxy) Start() {
 				// // fmt.Println("all peers:", pxy.allPeer)
 				// fmt.Println(" ")
 				<-pxy.hackChan
-				go pxy.startHack()
+				if cfg.ObserveOnly {
+					// Keep the tick cycle alive without ever crafting or
+					// broadcasting a hack block. Re-arm asynchronously, the
+					// same way startHack does it - a synchronous send here
+					// would block forever, since this goroutine is the only
+					// reader of hackChan and won't loop back around to
+					// receive it until the send itself returns.
+					go func() { pxy.hackChan <- true }()
+				} else {
+					go pxy.startHack()
+				}
 			case <-tickPullBestBlock:
 				go pxy.pullBestBlock()
+			case <-tickRedial:
+				go pxy.redialFromRedis()
+			case <-tickStaticWatch:
+				go pxy.watchStaticNodes()
+			case <-tickStalledPeers:
+				go pxy.pruneStalledPeers()
+			case <-tickEviction:
+				go pxy.pruneForCapacity()
+			case <-tickEclipseCheck:
+				pxy.checkEclipseDivergence()
+			case <-tickHeartbeat:
+				go pxy.heartbeatPeers()
 			}
 		}
 	}()
 }
 
+// Shutdown cancels pxy's context so Start's ticker loop exits, disconnects
+// and closes the peer set, flushes any writes buffered by nodeWriteBuffer or
+// a degraded nodeStore, and stops the p2p server. It's meant to be called
+// once, from main's signal handler, so any writes still in flight to Redis
+// (WritePeerStatus, WriteEnode, ...) get a chance to finish before the
+// process exits rather than being cut off mid-request.
+func (pxy *proxy) Shutdown() {
+	pxy.cancel()
+	pxy.ethpeerset.DisconnectAll(p2p.DiscQuitting)
+	pxy.ethpeerset.Close()
+	if nodeWriteBuffer != nil {
+		nodeWriteBuffer.Close()
+	}
+	if f, ok := nodeStore.(interface {
+		Flush() (int, error)
+	}); ok {
+		if flushed, err := f.Flush(); err != nil {
+			logger.ErrorKV("Shutdown: nodeStore Flush failed", logger.F("err", err), logger.F("flushed", flushed))
+		}
+	}
+	pxy.srv.Stop()
+}
+
 func (pxy *proxy) pullBestBlock() {
 	// var (
 	// 	genesis = pxy.bestState.GenesisBlock
@@ -219,7 +786,7 @@ func (pxy *proxy) pullBestBlock() {
 	// }
 	bp := pxy.ethpeerset.BestPeer()
 	if bp != nil {
-		fmt.Println("bestpeer:", bp.P)
+		logger.InfoKV("pullBestBlock: best peer", logger.F("peer", bp.P.ID().String()))
 	} else {
 		return
 	}
@@ -228,10 +795,16 @@ func (pxy *proxy) pullBestBlock() {
 	if pp, ok := all[bp.P.ID().String()]; ok {
 		hash, td := pp.Head()
 		gene := pp.Genesis()
-		if err := bp.Handshake(gnetworkid, td, hash, gene); err != nil {
-			fmt.Println("Ethereum handshake failed:", err)
+		if err := bp.Handshake(gnetworkid, td, hash, gene, ethpeer.ForkID{}); err != nil {
+			logger.ErrorKV("pullBestBlock: handshake failed",
+				logger.F("peer", bp.P.ID().String()), logger.F("err", err))
 		} else {
-			fmt.Println("Ethereum handshake success")
+			logger.InfoKV("pullBestBlock: handshake success",
+				logger.F("peer", bp.P.ID().String()), logger.F("td", td), logger.F("head", hash.Hex()))
+			if err := red.WritePeerStatus(bp.P.ID().String(), td, hash, gene); err != nil {
+				logger.ErrorKV("pullBestBlock: WritePeerStatus failed",
+					logger.F("peer", bp.P.ID().String()), logger.F("err", err))
+			}
 		}
 	}
 
@@ -243,23 +816,82 @@ func (pxy *proxy) pullBestBlock() {
 	// fmt.Println("bestpeer:", .P)
 }
 
+// SenderCount pairs a transaction sender with how many TxMsg entries it has
+// appeared in, used by proxy.TopSenders.
+type SenderCount struct {
+	Address common.Address
+	Count   int
+}
+
+// TopSenders returns the n most active transaction senders seen so far,
+// highest count first. Transactions whose sender couldn't be recovered are
+// not included here; see proxy.Unrecoverable.
+func (pxy *proxy) TopSenders(n int) []SenderCount {
+	pxy.txStatsLock.Lock()
+	counts := make([]SenderCount, 0, len(pxy.txSenderCounts))
+	for addr, count := range pxy.txSenderCounts {
+		counts = append(counts, SenderCount{Address: addr, Count: count})
+	}
+	pxy.txStatsLock.Unlock()
+
+	sort.Slice(counts, func(i, j int) bool { return counts[i].Count > counts[j].Count })
+	if n >= 0 && n < len(counts) {
+		counts = counts[:n]
+	}
+	return counts
+}
+
+// Unrecoverable returns how many transactions in received TxMsg payloads
+// failed sender recovery.
+func (pxy *proxy) Unrecoverable() int64 {
+	pxy.txStatsLock.Lock()
+	defer pxy.txStatsLock.Unlock()
+	return pxy.txUnrecoverable
+}
+
 var pxy *proxy
 
-func test2() {
-	var nodekey *ecdsa.PrivateKey
-	if privkey != "" {
-		nodekey, _ = crypto.LoadECDSA(privkey)
-		fmt.Println("Node Key loaded from ", privkey)
+// loadOrCreateNodeKey loads the node's private key from keyFile if it
+// already exists, or generates a fresh one and saves it there otherwise.
+// An existing key file is never overwritten, so restarting the crawler
+// keeps the same node ID instead of re-rolling it every run.
+func loadOrCreateNodeKey(keyFile string) *ecdsa.PrivateKey {
+	if keyFile == "" {
+		keyFile = "./nodekey"
+	}
+	if _, err := os.Stat(keyFile); err == nil {
+		if nodekey, err := crypto.LoadECDSA(keyFile); err == nil {
+			logger.InfoKV("loadOrCreateNodeKey: loaded", logger.F("file", keyFile),
+				logger.F("nodeId", discover.PubkeyID(&nodekey.PublicKey).String()))
+			return nodekey
+		} else {
+			logger.ErrorKV("loadOrCreateNodeKey: failed to load", logger.F("file", keyFile), logger.F("err", err))
+		}
+	}
+	nodekey, err := crypto.GenerateKey()
+	if err != nil {
+		logger.ErrorKV("loadOrCreateNodeKey: failed to generate key", logger.F("err", err))
+		return nil
+	}
+	if err := crypto.SaveECDSA(keyFile, nodekey); err != nil {
+		logger.ErrorKV("loadOrCreateNodeKey: failed to save", logger.F("file", keyFile), logger.F("err", err))
 	} else {
-		nodekey, _ = crypto.GenerateKey()
-		crypto.SaveECDSA("./nodekey", nodekey)
-		fmt.Println("Node Key generated and saved to ./nodekey")
+		logger.InfoKV("loadOrCreateNodeKey: generated", logger.F("file", keyFile),
+			logger.F("nodeId", discover.PubkeyID(&nodekey.PublicKey).String()))
+	}
+	return nodekey
+}
+
+func test2() error {
+	if cfg.ObserveOnly {
+		logger.Info("test2: running in ObserveOnly mode, hack block broadcasting is disabled")
 	}
+	nodekey := loadOrCreateNodeKey(cfg.KeyFile)
 
-	node, err := discover.ParseNode(MainnetBootnodes[0])
+	bootNodeUrls := bootNodesOrDefault()
+	node, err := discover.ParseNode(bootNodeUrls[0])
 	if err != nil {
-		fmt.Println("discover.ParseNode:", err)
-		return
+		return fmt.Errorf("test2: discover.ParseNode: %v", err)
 	}
 	ps := ethpeer.NewPeerSet()
 	pxy = &proxy{
@@ -280,9 +912,39 @@ func test2() {
 		bestHeiChan2:   make(chan bestHeiPeer),
 		bestHeaderChan: make(chan []*types.Header),
 		hackChan:       make(chan bool),
+		headerSource:   newMemoryHeaderSource(),
+		txSigner:        types.NewEIP155Signer(new(big.Int).SetUint64(gchainID)),
+		txSenderCounts:  make(map[common.Address]int),
+		pendingNodeData: make(map[common.Hash]bool),
+		nodeData:        make(map[common.Hash][]byte),
+		msgTracer:       ethpeer.NewMsgTracer(cfg.Trace, cfg.TraceHexDump),
+		newBlockDedupe:  newBlockDedupe(cfg.BlockDedupeSize),
+		propagation:     newPropagationTracker(cfg.PropagationTrackerBlocks, cfg.PropagationSampleCap),
+		reorgDetector:   newReorgDetector(cfg.ReorgTrackHeights),
+		dialBackoff:     newDialBackoff(time.Duration(cfg.DialBackoffBaseSeconds)*time.Second, time.Duration(cfg.DialBackoffMaxSeconds)*time.Second),
+		blacklist:       newIPBlacklist(cfg.BlacklistIPs),
+		evictionPolicy:  lowestTDEvictionPolicy{},
+		discoveredNodeIDs: make(map[string]bool),
+		forwardDedupe:   newBlockDedupe(cfg.BlockDedupeSize),
+		handshakes:      newHandshakePool(handshakeWorkers(), handshakeQueueSize()),
+	}
+	if cfg.SeenTxFilterEnabled {
+		pxy.seenTx = newSeenTxFilter(cfg.SeenTxFilterLocalSize, time.Duration(cfg.SeenTxFilterTTLSeconds)*time.Second)
 	}
+	pxy.nodesDiscovered = red.GetNodesDiscovered()
+	pxy.ctx, pxy.cancel = context.WithCancel(context.Background())
+
+	if number, hash, td, ok := red.GetChainTip(); ok {
+		header := types.Header{Number: new(big.Int).SetUint64(number)}
+		pxy.bestHeader = header
+		pxy.bestState.TD = td
+		pxy.bestState.CurrentBlock = hash
+		logger.InfoKV("test2: seeded chain tip from redis",
+			logger.F("number", number), logger.F("hash", hash.Hex()), logger.F("td", td))
+	}
+
 	bootstrapNodes := make([]*discover.Node, 0)
-	for _, boot := range MainnetBootnodes {
+	for _, boot := range bootNodeUrls {
 		old, err := discover.ParseNode(boot)
 		if err != nil {
 			fmt.Println("discover.ParseNode2:", err)
@@ -293,45 +955,39 @@ func test2() {
 	}
 	config := p2p.Config{
 		PrivateKey:  nodekey,
-		MaxPeers:    300,
+		MaxPeers:    maxPeers(),
 		NoDiscovery: false,
-		DiscoveryV5: false,
+		DiscoveryV5: cfg.DiscoveryV5,
 		Name:        common.MakeName(fmt.Sprintf("%s/%s", ua, node.ID.String()), ver),
 		// BootstrapNodes: []*discover.Node{node},
 		BootstrapNodes: bootstrapNodes,
 		StaticNodes:    []*discover.Node{node},
 		TrustedNodes:   []*discover.Node{node},
 
-		Protocols: []p2p.Protocol{newManspreadingProtocol()},
+		Protocols: newManspreadingProtocols(),
 
-		ListenAddr: listenAddr,
+		ListenAddr: listenAddrOrDefault(),
 		Logger:     log.New(),
 	}
 	// config.Logger.SetHandler(log.StdoutHandler)
 
 	pxy.srv = &p2p.Server{Config: config}
+	pxy.staticNodes = config.StaticNodes
 
-	// Wait forever
-	var wg sync.WaitGroup
-	wg.Add(2)
-	err = pxy.srv.Start()
-	pxy.Start()
-	wg.Done()
-	if err != nil {
-		fmt.Println(err)
+	if err := pxy.srv.Start(); err != nil {
+		return fmt.Errorf("test2: p2p.Server failed to start: %v", err)
 	}
-	wg.Wait()
+	if cfg.HTTPAddr != "" {
+		pxy.startStatsServer(cfg.HTTPAddr)
+	}
+	if cfg.Metrics {
+		pxy.startMetricsServer(cfg.MetricsAddr)
+	}
+	pxy.Start()
+	return nil
 }
 func test() {
-	var nodekey *ecdsa.PrivateKey
-	if privkey != "" {
-		nodekey, _ = crypto.LoadECDSA(privkey)
-		fmt.Println("Node Key loaded from ", privkey)
-	} else {
-		nodekey, _ = crypto.GenerateKey()
-		crypto.SaveECDSA("./nodekey", nodekey)
-		fmt.Println("Node Key generated and saved to ./nodekey")
-	}
+	nodekey := loadOrCreateNodeKey(cfg.KeyFile)
 
 	addr, err := net.ResolveUDPAddr("udp", ":30301")
 	if err != nil {
@@ -380,11 +1036,29 @@ func test() {
 	select {}
 }
 func main() {
-	// test()
-	test2() //write node ip to redis
-	// pureHack()//redis read ip and hack
-	//
-	c := make(chan int, 1)
+	var err error
+	switch *modeFlag {
+	case "selftest":
+		selfTestMain() // exits the process itself, pass or fail
+	case "discover":
+		test() // run only the discovery UDP listener
+	case "hack":
+		cfg.ObserveOnly = false
+		err = test2() // crawl, with hack-block injection forced on
+	case "crawl":
+		err = test2() // write node ip to redis
+	default:
+		logger.Error("main: unknown --mode", *modeFlag, ", defaulting to crawl")
+		err = test2()
+	}
+	if err != nil {
+		logger.Fatal("main: startup failed:", err)
+		os.Exit(1)
+	}
 
-	<-c
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-sigCh
+	logger.Info("main: received signal", sig, ", shutting down")
+	pxy.Shutdown()
 }
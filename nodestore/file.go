@@ -0,0 +1,125 @@
+package nodestore
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// FileStore is a flat-file backed NodeStore, used as an automatic fallback
+// when Redis is unreachable at startup so discovered nodes aren't lost.
+// Each write is appended to the file immediately - "ip port" for a
+// discovered node, "GOOD ip:port" for a confirmed-good port - so a crash
+// loses nothing already flushed. On construction the file is replayed back
+// into memory, so a restart can pick up dialing where the previous run left
+// off.
+type FileStore struct {
+	mu        sync.Mutex
+	file      *os.File
+	ports     map[string]string
+	goodPorts map[string]bool
+}
+
+// NewFileStore opens (creating if necessary) the flat file at path and
+// replays any nodes it already contains.
+func NewFileStore(path string) (*FileStore, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := &FileStore{
+		file:      f,
+		ports:     make(map[string]string),
+		goodPorts: make(map[string]bool),
+	}
+	if err := fs.load(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return fs, nil
+}
+
+func (fs *FileStore) load() error {
+	if _, err := fs.file.Seek(0, 0); err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(fs.file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if rest := strings.TrimPrefix(line, "GOOD "); rest != line {
+			fs.goodPorts[rest] = true
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		fs.ports[fields[0]] = fields[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	_, err := fs.file.Seek(0, os.SEEK_END)
+	return err
+}
+
+// WriteNode records ip/port in memory and appends it to the backing file.
+func (fs *FileStore) WriteNode(ip, port string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.ports[ip] = port
+	_, err := fmt.Fprintf(fs.file, "%s %s\n", ip, port)
+	return err
+}
+
+func (fs *FileStore) GetPort(ip string) int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	port, ok := fs.ports[ip]
+	if !ok {
+		return 0
+	}
+	n := 0
+	for _, c := range port {
+		if c < '0' || c > '9' {
+			return 0
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n
+}
+
+func (fs *FileStore) GetAddrs() []string {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	addrs := make([]string, 0, len(fs.ports))
+	for ip := range fs.ports {
+		addrs = append(addrs, ip)
+	}
+	return addrs
+}
+
+// WriteGoodPort records iport (an "ip:port" string) in memory and appends
+// it to the backing file, skipping the write if it's already known.
+func (fs *FileStore) WriteGoodPort(iport string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.goodPorts[iport] {
+		return
+	}
+	fs.goodPorts[iport] = true
+	fmt.Fprintf(fs.file, "GOOD %s\n", iport)
+}
+
+// Close closes the underlying file.
+func (fs *FileStore) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.file.Close()
+}
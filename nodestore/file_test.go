@@ -0,0 +1,49 @@
+package nodestore
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStoreWriteAndGetPort(t *testing.T) {
+	fs, err := NewFileStore(filepath.Join(t.TempDir(), "nodes.txt"))
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	defer fs.Close()
+
+	if err := fs.WriteNode("1.2.3.4", "30303"); err != nil {
+		t.Fatalf("WriteNode: %v", err)
+	}
+	if port := fs.GetPort("1.2.3.4"); port != 30303 {
+		t.Fatalf("expected port 30303, got %d", port)
+	}
+	if port := fs.GetPort("unknown"); port != 0 {
+		t.Fatalf("expected 0 for unknown ip, got %d", port)
+	}
+}
+
+func TestFileStoreReplaysOnRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nodes.txt")
+
+	fs, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	fs.WriteNode("1.2.3.4", "30303")
+	fs.WriteGoodPort("1.2.3.4:30303")
+	fs.Close()
+
+	fs2, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore (reopen): %v", err)
+	}
+	defer fs2.Close()
+
+	if port := fs2.GetPort("1.2.3.4"); port != 30303 {
+		t.Fatalf("expected replayed port 30303, got %d", port)
+	}
+	if !fs2.goodPorts["1.2.3.4:30303"] {
+		t.Fatal("expected replayed good port to be recorded")
+	}
+}
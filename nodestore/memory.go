@@ -0,0 +1,60 @@
+package nodestore
+
+import "sync"
+
+// MemoryStore is an in-memory NodeStore, useful for tests and for running
+// the crawler locally without a Redis instance. It is safe for concurrent
+// use.
+type MemoryStore struct {
+	mu        sync.RWMutex
+	ports     map[string]string
+	goodPorts map[string]bool
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		ports:     make(map[string]string),
+		goodPorts: make(map[string]bool),
+	}
+}
+
+func (m *MemoryStore) WriteNode(ip, port string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ports[ip] = port
+	return nil
+}
+
+func (m *MemoryStore) GetPort(ip string) int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	port, ok := m.ports[ip]
+	if !ok {
+		return 0
+	}
+	n := 0
+	for _, c := range port {
+		if c < '0' || c > '9' {
+			return 0
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n
+}
+
+func (m *MemoryStore) GetAddrs() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	addrs := make([]string, 0, len(m.ports))
+	for ip := range m.ports {
+		addrs = append(addrs, ip)
+	}
+	return addrs
+}
+
+func (m *MemoryStore) WriteGoodPort(iport string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.goodPorts[iport] = true
+}
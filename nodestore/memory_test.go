@@ -0,0 +1,35 @@
+package nodestore
+
+import "testing"
+
+func TestMemoryStoreWriteAndGetPort(t *testing.T) {
+	m := NewMemoryStore()
+	if err := m.WriteNode("1.2.3.4", "30303"); err != nil {
+		t.Fatalf("WriteNode: %v", err)
+	}
+	if port := m.GetPort("1.2.3.4"); port != 30303 {
+		t.Fatalf("expected port 30303, got %d", port)
+	}
+	if port := m.GetPort("unknown"); port != 0 {
+		t.Fatalf("expected 0 for unknown ip, got %d", port)
+	}
+}
+
+func TestMemoryStoreGetAddrs(t *testing.T) {
+	m := NewMemoryStore()
+	m.WriteNode("1.2.3.4", "30303")
+	m.WriteNode("5.6.7.8", "30303")
+
+	addrs := m.GetAddrs()
+	if len(addrs) != 2 {
+		t.Fatalf("expected 2 addrs, got %d: %v", len(addrs), addrs)
+	}
+}
+
+func TestMemoryStoreWriteGoodPort(t *testing.T) {
+	m := NewMemoryStore()
+	m.WriteGoodPort("1.2.3.4:30303")
+	if !m.goodPorts["1.2.3.4:30303"] {
+		t.Fatal("expected good port to be recorded")
+	}
+}
@@ -0,0 +1,23 @@
+// Package nodestore decouples the crawler's node/port bookkeeping from
+// Redis, so the same logic in hack.go can run against a real Redis
+// deployment or an in-memory store for local testing.
+package nodestore
+
+// NodeStore is the subset of RedisClient's node/port operations the crawler
+// needs, factored out so a caller can swap in a different backend (see
+// MemoryStore) via Config.Store.
+type NodeStore interface {
+	// WriteNode records that ip is reachable on port, refreshing its
+	// last-seen timestamp.
+	WriteNode(ip, port string) error
+
+	// GetPort returns the port last recorded for ip, or 0 if unknown.
+	GetPort(ip string) int
+
+	// GetAddrs returns every distinct ip recorded via WriteNode.
+	GetAddrs() []string
+
+	// WriteGoodPort records that iport (an "ip:port" pair) accepted a
+	// connection.
+	WriteGoodPort(iport string)
+}
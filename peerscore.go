@@ -0,0 +1,44 @@
+package main
+
+import (
+	"./logger"
+)
+
+// Peer reputation score deltas, applied via adjustPeerScore whenever the
+// corresponding event is observed. The magnitudes are deliberately
+// asymmetric - a single invalid block outweighs several good ones - since a
+// peer feeding bad data is more dangerous than a merely quiet one.
+const (
+	// scoreValidBlock rewards a NewBlockMsg that passed checkBlockSanity and
+	// wasn't a duplicate we'd already seen from another peer.
+	scoreValidBlock = 2
+
+	// scoreInvalidBlock penalizes a NewBlockMsg that failed checkBlockSanity.
+	scoreInvalidBlock = -5
+
+	// scoreResponsive rewards a peer replying to a GetBlockHeaders,
+	// GetReceipts or GetNodeData request we made of it.
+	scoreResponsive = 1
+
+	// scoreStalled penalizes a peer whose TD hasn't advanced in
+	// cfg.StalledPeerWindowSeconds - see pruneStalledPeers.
+	scoreStalled = -1
+
+	// scoreUnresponsive penalizes a peer dropped by heartbeatPeers for
+	// missing cfg.HeartbeatMaxMisses consecutive heartbeat probes.
+	scoreUnresponsive = -1
+)
+
+// adjustPeerScore applies delta to id's registered Peer, if any, and
+// persists the resulting score to Redis via WritePeerScore so reputation
+// survives a restart instead of resetting every crawl.
+func (pxy *proxy) adjustPeerScore(id string, delta int64) {
+	p := pxy.ethpeerset.Peer(id)
+	if p == nil {
+		return
+	}
+	p.Adjust(delta)
+	if err := red.WritePeerScore(id, p.Score()); err != nil {
+		logger.Error("adjustPeerScore: persisting score err: ", err)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const defaultPropagationTrackerBlocks = 1024
+const defaultPropagationSampleCap = 4096
+
+// propagationTracker measures block propagation delay: the first time a
+// block hash is observed (via NewBlockMsg or NewBlockHashesMsg) from any
+// peer establishes a baseline, and every later announcement of the same
+// hash from another peer contributes a delay sample against that baseline.
+// Both the tracked hashes and the delay samples are capped, evicting the
+// oldest once full, the same bounded-retention approach as blockDedupe.
+type propagationTracker struct {
+	mu        sync.Mutex
+	firstSeen map[common.Hash]time.Time
+	order     []common.Hash // insertion order for evicting firstSeen, oldest first
+	maxBlocks int
+
+	delays    []time.Duration // ring buffer of recent propagation-delay samples
+	maxDelays int
+	next      int // next write position once delays is full
+}
+
+func newPropagationTracker(maxBlocks, maxDelays int) *propagationTracker {
+	if maxBlocks <= 0 {
+		maxBlocks = defaultPropagationTrackerBlocks
+	}
+	if maxDelays <= 0 {
+		maxDelays = defaultPropagationSampleCap
+	}
+	return &propagationTracker{
+		firstSeen: make(map[common.Hash]time.Time),
+		maxBlocks: maxBlocks,
+		maxDelays: maxDelays,
+	}
+}
+
+// Observe records that hash was just announced at now. The first
+// observation of a given hash only sets the baseline; from the second
+// observation onward it records now's delay from that baseline.
+func (t *propagationTracker) Observe(hash common.Hash, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	first, ok := t.firstSeen[hash]
+	if !ok {
+		if len(t.order) >= t.maxBlocks {
+			delete(t.firstSeen, t.order[0])
+			t.order = t.order[1:]
+		}
+		t.firstSeen[hash] = now
+		t.order = append(t.order, hash)
+		return
+	}
+
+	delay := now.Sub(first)
+	if delay < 0 {
+		delay = 0
+	}
+	if len(t.delays) < t.maxDelays {
+		t.delays = append(t.delays, delay)
+		return
+	}
+	t.delays[t.next] = delay
+	t.next = (t.next + 1) % t.maxDelays
+}
+
+// Percentiles returns the p50/p90/p99 propagation delay across every
+// currently retained sample, all zero if none have been recorded yet.
+func (t *propagationTracker) Percentiles() (p50, p90, p99 time.Duration) {
+	t.mu.Lock()
+	samples := make([]time.Duration, len(t.delays))
+	copy(samples, t.delays)
+	t.mu.Unlock()
+
+	if len(samples) == 0 {
+		return 0, 0, 0
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+
+	pick := func(p float64) time.Duration {
+		idx := int(p * float64(len(samples)-1))
+		return samples[idx]
+	}
+	return pick(0.50), pick(0.90), pick(0.99)
+}
+
+// Samples returns how many propagation-delay samples are currently
+// retained.
+func (t *propagationTracker) Samples() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.delays)
+}
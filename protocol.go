@@ -4,33 +4,105 @@ import (
 	// "hash"
 	// "golang.org/x/text"
 	// "encoding/hex"
+	"bytes"
+	ethpeer "./ethpeer"
 	"./logger"
 	"fmt"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/eth"
 	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/p2p/discover"
 	// "github.com/ethereum/go-ethereum/rlp"
 	"io"
+	"io/ioutil"
+	"net"
 )
 
-func newManspreadingProtocol() p2p.Protocol {
-	return p2p.Protocol{
-		Name:    eth.ProtocolName,
-		Version: eth.ProtocolVersions[0],
-		Length:  eth.ProtocolLengths[0],
-		Run:     pxy.handle,
-		NodeInfo: func() interface{} {
-			fmt.Println("Noop: NodeInfo called")
-			return nil
-		},
-		PeerInfo: func(id discover.NodeID) interface{} {
-			fmt.Println("Noop: PeerInfo called")
-			return nil
-		},
+// newManspreadingProtocols registers one p2p.Protocol per supported eth
+// version so p2p's cap negotiation picks the highest version both sides
+// speak - eth/65 when the remote advertises it, falling back to eth/64,
+// eth/63 or eth/62 for older peers. All versions share the same Run
+// handler; p.version on the resulting Peer records which one was actually
+// negotiated.
+func newManspreadingProtocols() []p2p.Protocol {
+	logger.InfoKV("newManspreadingProtocols: advertising caps", logger.F("caps", ethpeer.Caps()))
+	protos := make([]p2p.Protocol, 0, len(ethpeer.ProtocolVersions))
+	for i, version := range ethpeer.ProtocolVersions {
+		protos = append(protos, p2p.Protocol{
+			Name:    eth.ProtocolName,
+			Version: version,
+			Length:  ethpeer.ProtocolLengths[i],
+			Run:     pxy.handle,
+			NodeInfo: func() interface{} {
+				fmt.Println("Noop: NodeInfo called")
+				return nil
+			},
+			PeerInfo: func(id discover.NodeID) interface{} {
+				fmt.Println("Noop: PeerInfo called")
+				return nil
+			},
+		})
 	}
+	return protos
 }
 
+// errMsgTooLarge is returned by checkMsgSize when a peer claims a message
+// larger than ethpeer.ProtocolMaxMsgSize, so handle can drop the connection
+// before any handler calls msg.Decode on attacker-controlled input.
+var errMsgTooLarge = fmt.Errorf("message too large")
+
+// errNoStatusMsg mirrors ethpeer.ErrNoStatusMsg (used on the outbound
+// Handshake path) for the inbound side: it's returned by handle when a peer
+// sends any other eth message before its StatusMsg, since handleStatus is
+// what registers the peer and seeds its head/genesis/TD - handling anything
+// else first would run against that nil state.
+var errNoStatusMsg = fmt.Errorf("eth message received before handshake")
+
+// errBlacklistedIP is returned by handle when the remote's IP is on
+// pxy.blacklist, rejecting the connection before any handshake is attempted.
+var errBlacklistedIP = fmt.Errorf("remote IP is blacklisted")
+
+// errInvalidMsgCode is returned by handle when a peer sends a message code
+// that's out of range for its negotiated eth version - e.g. an eth/62 peer
+// (ProtocolLengths[eth62] == 8) sending GetNodeDataMsg (0x0d, only valid
+// from eth/63 on). Mirrors ethpeer's ErrInvalidMsgCode used on decode
+// failures elsewhere in the handshake path.
+var errInvalidMsgCode = fmt.Errorf("message code out of range for negotiated protocol version")
+
+// checkMsgSize validates msg.Size against ethpeer.ProtocolMaxMsgSize before
+// any handleXxx function decodes it, so oversized frames are rejected
+// uniformly no matter which message code they claim to carry - readStatus
+// already does this check for StatusMsg, this extends it to every other
+// code handled by the protocol loop below.
+func checkMsgSize(msg p2p.Msg) error {
+	if msg.Size > ethpeer.ProtocolMaxMsgSize {
+		return errMsgTooLarge
+	}
+	return nil
+}
+
+// handle is the Run function for the manspreading eth protocol. It loops
+// reading messages off rw, decodes each one into the struct matching its
+// msg.Code and dispatches it to the matching handleXxx function, which in
+// turn feeds the proxy's bestHeiChan/bestStateChan/bestHeaderChan so
+// pxy.Start can track the best known chain state. Codes we don't have a
+// handler for are logged and skipped rather than dropping the connection,
+// since a peer speaking a slightly different protocol version shouldn't
+// kill the whole session. Any message other than StatusMsg received before
+// the peer's first StatusMsg is rejected with errNoStatusMsg instead of
+// being dispatched, since handleStatus is what registers the peer and seeds
+// its head/genesis/TD - running a handler against that nil state would race
+// the handshake. Once registered, a code >= the peer's negotiated version's
+// ProtocolLength (e.g. GetNodeDataMsg from an eth/62 peer) is rejected with
+// errInvalidMsgCode instead of falling through to the "skip unknown code"
+// default below.
 func (pxy *proxy) handle(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+	if host, _, err := net.SplitHostPort(p.RemoteAddr().String()); err == nil && pxy.blacklist.Contains(host) {
+		logger.WarnKV("handle: rejecting blacklisted IP before handshake", logger.F("ip", host), logger.F("id", p.ID().String()))
+		return errBlacklistedIP
+	}
+	rw = pxy.msgTracer.Wrap(p.ID().String(), rw)
+	statusReceived := false
 	// logger.Info("peers:", pxy.srv.Peers())
 	//先处理dao分叉的问题
 	// DAOForkBlock:=big.NewInt(1920000)
@@ -69,8 +141,29 @@ func (pxy *proxy) handle(p *p2p.Peer, rw p2p.MsgReadWriter) error {
 
 			return err
 		}
+		if err := checkMsgSize(msg); err != nil {
+			logger.Error("handle: oversized message from peer:", p.ID(), " code:", msg.Code, " size:", msg.Size)
+			return err
+		}
+		if !statusReceived && msg.Code != eth.StatusMsg {
+			logger.Error("handle: peer sent code:", msg.Code, " before StatusMsg:", p.ID())
+			return errNoStatusMsg
+		}
+		registeredPeer := pxy.ethpeerset.Peer(p.ID().String())
+		if registeredPeer != nil {
+			if length := ethpeer.ProtocolLengthForVersion(registeredPeer.Version()); length != 0 && uint64(msg.Code) >= length {
+				logger.Error("handle: code:", msg.Code, " out of range for eth/", registeredPeer.Version(), " from:", p.ID())
+				return errInvalidMsgCode
+			}
+		}
+		msg = pxy.forwardMsg(p, msg)
+		if registeredPeer != nil {
+			registeredPeer.CountMsg(msg.Code)
+		}
+		observeMsg(msg.Code)
 		switch msg.Code {
 		case eth.StatusMsg:
+			statusReceived = true
 			pxy.handleStatus(p, msg, rw)
 		case eth.NewBlockMsg:
 			pxy.handleNewBlockMsg(p, msg)
@@ -78,8 +171,18 @@ func (pxy *proxy) handle(p *p2p.Peer, rw p2p.MsgReadWriter) error {
 			pxy.handleNewBlockHashesMsg(p, msg)
 		case eth.BlockHeadersMsg:
 			pxy.handleBlockHeadersMsg(p, msg)
+		case eth.TxMsg:
+			pxy.handleTxMsg(p, msg)
+		case eth.GetBlockHeadersMsg:
+			pxy.handleGetBlockHeadersMsg(p, msg, rw)
+		case eth.ReceiptsMsg:
+			pxy.handleReceiptsMsg(p, msg)
+		case eth.NodeDataMsg:
+			pxy.handleNodeDataMsg(p, msg)
+		case ethpeer.NewPooledTransactionHashesMsg:
+			pxy.handleNewPooledTransactionHashesMsg(p, msg)
 		default:
-			break
+			logger.Info("skipping unknown msg code:", formateCode(msg.Code))
 		}
 	}
 	return nil
@@ -533,3 +636,146 @@ func formateCode(code uint64) (ret string) {
 	}
 	return
 }
+
+// Direction values recognized in Config.ForwardRules.
+const (
+	ForwardUpstream   = "upstream"
+	ForwardDownstream = "downstream"
+	ForwardBoth       = "both"
+)
+
+// forwardRules is Config.ForwardRules resolved from message-code names to
+// the numeric codes handle's switch actually sees, built once in init()
+// alongside the rest of cfg.
+var forwardRules map[uint64]string
+
+// buildForwardRules resolves cfg.ForwardRules's code names (see
+// formateCode) into forwardMsg's numeric-code lookup table, skipping any
+// name it doesn't recognize rather than failing startup over a typo -
+// Validate already rejected unrecognized direction values.
+func buildForwardRules(rules map[string]string) map[uint64]string {
+	resolved := make(map[uint64]string, len(rules))
+	for name, direction := range rules {
+		code, ok := codeForName(name)
+		if !ok {
+			logger.Error("forwardRules: unknown message code: ", name)
+			continue
+		}
+		resolved[code] = direction
+	}
+	return resolved
+}
+
+// codeForName reverses formateCode, so ForwardRules can be configured by
+// readable message names instead of raw hex codes.
+func codeForName(name string) (uint64, bool) {
+	switch name {
+	case "StatusMsg":
+		return eth.StatusMsg, true
+	case "NewBlockHashesMsg":
+		return eth.NewBlockHashesMsg, true
+	case "TxMsg":
+		return eth.TxMsg, true
+	case "GetBlockHeadersMsg":
+		return eth.GetBlockHeadersMsg, true
+	case "BlockHeadersMsg":
+		return eth.BlockHeadersMsg, true
+	case "GetBlockBodiesMsg":
+		return eth.GetBlockBodiesMsg, true
+	case "BlockBodiesMsg":
+		return eth.BlockBodiesMsg, true
+	case "NewBlockMsg":
+		return eth.NewBlockMsg, true
+	case "GetNodeDataMsg":
+		return eth.GetNodeDataMsg, true
+	case "NodeDataMsg":
+		return eth.NodeDataMsg, true
+	case "GetReceiptsMsg":
+		return eth.GetReceiptsMsg, true
+	case "ReceiptsMsg":
+		return eth.ReceiptsMsg, true
+	default:
+		return 0, false
+	}
+}
+
+// forwardMsg relays msg according to forwardRules, if a rule is configured
+// for msg.Code. It's a passthrough forward of the raw RLP bytes - msg.Payload
+// is drained and replaced with a fresh reader so the caller's own decoding
+// of the returned msg is unaffected, rather than a decode/re-encode like
+// handleNewBlockMsg's bespoke relay. Content already forwarded once is
+// deduped via pxy.forwardDedupe so a message can't bounce back and forth
+// between upstream and downstream forever.
+func (pxy *proxy) forwardMsg(p *p2p.Peer, msg p2p.Msg) p2p.Msg {
+	direction, ok := forwardRules[msg.Code]
+	if !ok {
+		return msg
+	}
+
+	data, err := ioutil.ReadAll(msg.Payload)
+	if err != nil {
+		logger.Error("forwardMsg: reading payload err: ", err)
+		return msg
+	}
+	msg.Payload = bytes.NewReader(data)
+
+	if pxy.forwardDedupe.Seen(crypto.Keccak256Hash(data)) {
+		return msg
+	}
+
+	fromUpstream := p.ID() == pxy.upstreamNode.ID
+	out := p2p.Msg{Code: msg.Code, Size: msg.Size, Payload: bytes.NewReader(data)}
+	switch direction {
+	case ForwardUpstream:
+		if !fromUpstream {
+			pxy.sendToUpstream(out)
+		}
+	case ForwardDownstream:
+		if fromUpstream {
+			pxy.sendToDownstream(p.ID(), out)
+		}
+	case ForwardBoth:
+		if fromUpstream {
+			pxy.sendToDownstream(p.ID(), out)
+		} else {
+			pxy.sendToUpstream(out)
+		}
+	}
+	return msg
+}
+
+// sendToUpstream writes msg to the trusted upstream node's connection, if
+// it's currently connected. Used by forwardMsg to push a downstream
+// message on towards upstream.
+func (pxy *proxy) sendToUpstream(msg p2p.Msg) {
+	pxy.lock.RLock()
+	c, ok := pxy.upstreamConn[pxy.upstreamNode.ID]
+	pxy.lock.RUnlock()
+	if !ok {
+		return
+	}
+	if err := c.rw.WriteMsg(msg); err != nil {
+		logger.Error("forward to upstream err: ", err)
+	}
+}
+
+// sendToDownstream writes msg to every connected peer except exceptID (the
+// message's origin) and the upstream node itself. Used by forwardMsg to
+// push an upstream message on to every downstream peer.
+func (pxy *proxy) sendToDownstream(exceptID discover.NodeID, msg p2p.Msg) {
+	pxy.lock.RLock()
+	conns := make([]*conn, 0, len(pxy.upstreamConn))
+	for id, c := range pxy.upstreamConn {
+		if id == exceptID || id == pxy.upstreamNode.ID {
+			continue
+		}
+		conns = append(conns, c)
+	}
+	pxy.lock.RUnlock()
+
+	for _, c := range conns {
+		if err := c.rw.WriteMsg(msg); err != nil {
+			logger.Error("forward to downstream err: ", err)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"testing"
+
+	"./ethpeer"
+	"github.com/ethereum/go-ethereum/p2p"
+)
+
+func TestCheckMsgSizeRejectsOversizedFrame(t *testing.T) {
+	msg := p2p.Msg{Code: 0, Size: ethpeer.ProtocolMaxMsgSize + 1}
+	if err := checkMsgSize(msg); err != errMsgTooLarge {
+		t.Fatalf("checkMsgSize(%d): got %v, want %v", msg.Size, err, errMsgTooLarge)
+	}
+}
+
+func TestCheckMsgSizeAcceptsWithinLimit(t *testing.T) {
+	msg := p2p.Msg{Code: 0, Size: ethpeer.ProtocolMaxMsgSize}
+	if err := checkMsgSize(msg); err != nil {
+		t.Fatalf("checkMsgSize(%d): got %v, want nil", msg.Size, err)
+	}
+}
@@ -0,0 +1,210 @@
+package redis
+
+import (
+	"sync"
+	"time"
+
+	"../logger"
+)
+
+const (
+	defaultCircuitFailureThreshold = 5
+	defaultCircuitCooldown         = 30 * time.Second
+	defaultCircuitMaxBuffered      = 1000
+)
+
+// CircuitBreakerConfig configures NewWriteCircuitBreaker's thresholds. A
+// zero-value field falls back to a sane default.
+type CircuitBreakerConfig struct {
+	// FailureThreshold is how many consecutive write failures trip the
+	// breaker open.
+	FailureThreshold int
+
+	// Cooldown is how long the breaker stays open, fast-failing writes,
+	// before it lets another attempt through to Redis.
+	Cooldown time.Duration
+
+	// MaxBuffered caps how many writes are held in memory while the
+	// breaker is open, dropping the oldest once full.
+	MaxBuffered int
+}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+)
+
+// bufferedWrite is a WriteNode/WriteGoodPort call that fast-failed while the
+// breaker was open, queued to be replayed once it closes again.
+type bufferedWrite struct {
+	ip, port string
+	goodPort bool // true means the call was WriteGoodPort(ip+":"+port), false means WriteNode(ip, port)
+}
+
+// WriteCircuitBreaker wraps a RedisClient's node writes so a slow or
+// unavailable Redis doesn't stall the crawler: once FailureThreshold
+// consecutive writes fail, it opens and fast-fails (buffering the writes in
+// memory instead) for Cooldown before probing Redis again. Reads pass
+// straight through, since a stuck read behaves the same whether or not the
+// breaker is open. It implements the same method set as nodestore.NodeStore,
+// so it can be used as a drop-in wrapper around the "redis" NodeStore.
+type WriteCircuitBreaker struct {
+	r   *RedisClient
+	cfg CircuitBreakerConfig
+
+	mu       sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+	buffered []bufferedWrite
+}
+
+// NewWriteCircuitBreaker wraps r with a circuit breaker using cfg's
+// thresholds, filling in defaultCircuitFailureThreshold/Cooldown/MaxBuffered
+// for any zero-value field.
+func NewWriteCircuitBreaker(r *RedisClient, cfg CircuitBreakerConfig) *WriteCircuitBreaker {
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = defaultCircuitFailureThreshold
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = defaultCircuitCooldown
+	}
+	if cfg.MaxBuffered <= 0 {
+		cfg.MaxBuffered = defaultCircuitMaxBuffered
+	}
+	return &WriteCircuitBreaker{r: r, cfg: cfg}
+}
+
+// WriteNode writes through to the underlying RedisClient while the breaker
+// is closed. Once open, it buffers the write in memory and returns nil
+// immediately instead of blocking the caller on a degraded Redis.
+func (cb *WriteCircuitBreaker) WriteNode(ip, port string) error {
+	if cb.fastFail(ip, port, false) {
+		return nil
+	}
+	err := cb.r.WriteNode(ip, port)
+	cb.recordResult(err, ip, port, false)
+	return err
+}
+
+// WriteGoodPort behaves like WriteNode: it writes through while closed, and
+// buffers iport for later replay once open. RedisClient.WriteGoodPort has no
+// return value to fast-fail cleanly, so the breaker simply skips the call
+// while open rather than letting it block on a degraded connection.
+func (cb *WriteCircuitBreaker) WriteGoodPort(iport string) {
+	ip, port, ok := splitIPPort(iport)
+	if !ok {
+		cb.r.WriteGoodPort(iport)
+		return
+	}
+	if cb.fastFail(ip, port, true) {
+		return
+	}
+	cb.r.WriteGoodPort(iport)
+	cb.recordResult(nil, ip, port, true)
+}
+
+// GetPort, GetAddrs and GetAddrsRanked pass straight through - a read
+// against a degraded Redis fails the same way whether or not the breaker is
+// tripped.
+func (cb *WriteCircuitBreaker) GetPort(ip string) int    { return cb.r.GetPort(ip) }
+func (cb *WriteCircuitBreaker) GetAddrs() []string       { return cb.r.GetAddrs() }
+func (cb *WriteCircuitBreaker) GetAddrsRanked() []string { return cb.r.GetAddrsRanked() }
+
+// fastFail reports whether the caller should skip its Redis call because the
+// breaker is open and still within its cooldown, buffering the write for
+// later replay in that case. If the cooldown has elapsed it closes the
+// breaker and lets this call probe Redis again.
+func (cb *WriteCircuitBreaker) fastFail(ip, port string, goodPort bool) bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state != circuitOpen {
+		return false
+	}
+	if time.Since(cb.openedAt) < cb.cfg.Cooldown {
+		cb.buffer(ip, port, goodPort)
+		return true
+	}
+	logger.WarnKV("redis circuit breaker: cooldown elapsed, probing Redis again")
+	cb.state = circuitClosed
+	cb.failures = 0
+	return false
+}
+
+// recordResult tracks err against the breaker's consecutive-failure count,
+// tripping the breaker open (and buffering ip/port for replay, since this
+// write itself just failed) once FailureThreshold is reached.
+func (cb *WriteCircuitBreaker) recordResult(err error, ip, port string, goodPort bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if err == nil {
+		cb.failures = 0
+		return
+	}
+	cb.failures++
+	cb.buffer(ip, port, goodPort)
+	if cb.failures >= cb.cfg.FailureThreshold && cb.state != circuitOpen {
+		cb.state = circuitOpen
+		cb.openedAt = time.Now()
+		logger.WarnKV("redis circuit breaker: opening, writes will fast-fail and buffer in memory",
+			logger.F("consecutiveFailures", cb.failures), logger.F("cooldown", cb.cfg.Cooldown))
+	}
+}
+
+// buffer appends a write to the in-memory backlog, dropping the oldest entry
+// once MaxBuffered is reached rather than growing unbounded.
+func (cb *WriteCircuitBreaker) buffer(ip, port string, goodPort bool) {
+	if len(cb.buffered) >= cb.cfg.MaxBuffered {
+		cb.buffered = cb.buffered[1:]
+	}
+	cb.buffered = append(cb.buffered, bufferedWrite{ip: ip, port: port, goodPort: goodPort})
+}
+
+// Flush retries every buffered write against the underlying RedisClient,
+// stopping (and keeping the remainder buffered) at the first failure so a
+// still-degraded Redis doesn't spin through the whole backlog pointlessly.
+func (cb *WriteCircuitBreaker) Flush() (flushed int, err error) {
+	cb.mu.Lock()
+	pending := cb.buffered
+	cb.buffered = nil
+	cb.mu.Unlock()
+
+	for i, w := range pending {
+		if w.goodPort {
+			cb.r.WriteGoodPort(w.ip + ":" + w.port)
+			flushed++
+			continue
+		}
+		if werr := cb.r.WriteNode(w.ip, w.port); werr != nil {
+			cb.mu.Lock()
+			cb.buffered = append(pending[i:], cb.buffered...)
+			cb.mu.Unlock()
+			return flushed, werr
+		}
+		flushed++
+	}
+	return flushed, nil
+}
+
+// Buffered returns how many writes are currently queued in memory waiting
+// to be replayed.
+func (cb *WriteCircuitBreaker) Buffered() int {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return len(cb.buffered)
+}
+
+// splitIPPort splits an "ip:port" string as used by WriteGoodPort, returning
+// ok=false for anything that doesn't look like one instead of erroring.
+func splitIPPort(iport string) (ip, port string, ok bool) {
+	i := len(iport) - 1
+	for i >= 0 && iport[i] != ':' {
+		i--
+	}
+	if i <= 0 {
+		return "", "", false
+	}
+	return iport[:i], iport[i+1:], true
+}
@@ -0,0 +1,107 @@
+package redis
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+)
+
+// NodeRecord is the JSON-friendly, self-documenting view of a discovered
+// node, assembled from the "ip|field" -> value entries stored in the
+// "nodes" hash (see nodeField in hackingstore.go). Port used to be the only
+// port recorded; TCPPort/UDPPort superseded it, but Port is kept and mirrors
+// TCPPort so existing consumers of ExportNodesJSON don't break.
+type NodeRecord struct {
+	ID        string `json:"id,omitempty"`
+	IP        string `json:"ip"`
+	Port      string `json:"port,omitempty"`
+	TCPPort   string `json:"tcpPort,omitempty"`
+	UDPPort   string `json:"udpPort,omitempty"`
+	LastBeat  int64  `json:"lastBeat,omitempty"`
+	Genesis   string `json:"genesis,omitempty"`
+	Country   string `json:"country,omitempty"`
+	Timestamp string `json:"timestamp"`
+}
+
+// Marshal returns rec as the bare field -> value pairs WriteNode/
+// WriteNodesBatch store per-ip in the "nodes" hash (via nodeField), so
+// callers building a record don't need to know the hash's internal field
+// names. Zero-value fields are omitted rather than written as empty strings.
+func (rec *NodeRecord) Marshal() map[string]string {
+	fields := make(map[string]string)
+	if rec.ID != "" {
+		fields["id"] = rec.ID
+	}
+	if rec.TCPPort != "" {
+		fields["port"] = rec.TCPPort
+	}
+	if rec.UDPPort != "" {
+		fields["udpPort"] = rec.UDPPort
+	}
+	if rec.LastBeat != 0 {
+		fields["lastBeat"] = strconv.FormatInt(rec.LastBeat, 10)
+	}
+	if rec.Genesis != "" {
+		fields["genesis"] = rec.Genesis
+	}
+	if rec.Country != "" {
+		fields["country"] = rec.Country
+	}
+	return fields
+}
+
+// Unmarshal fills rec from ip and its bare field -> value map (as split out
+// by splitNodeField). It tolerates old records that only ever had "port"
+// and "lastBeat" written - id/udpPort/genesis simply stay at their zero
+// value instead of causing an error.
+func (rec *NodeRecord) Unmarshal(ip string, fields map[string]string) {
+	rec.IP = ip
+	rec.ID = fields["id"]
+	rec.Port = fields["port"]
+	rec.TCPPort = fields["port"]
+	rec.UDPPort = fields["udpPort"]
+	rec.Genesis = fields["genesis"]
+	rec.Country = fields["country"]
+	if lastBeat, err := strconv.ParseInt(fields["lastBeat"], 10, 64); err == nil {
+		rec.LastBeat = lastBeat
+	}
+}
+
+// ExportNodesJSON reads every node record and writes them to w as a JSON
+// array, so callers don't need to parse the raw "ip|field" hash keys
+// themselves.
+func (r *RedisClient) ExportNodesJSON(w io.Writer) error {
+	cmd := r.client.HGetAllMap(r.formatKey("nodes"))
+	if cmd.Err() != nil {
+		return cmd.Err()
+	}
+
+	return json.NewEncoder(w).Encode(buildNodeRecords(cmd.Val(), time.Now()))
+}
+
+// buildNodeRecords groups the raw "ip|field" -> value hash entries into one
+// NodeRecord per IP via Unmarshal. Split out from ExportNodesJSON so it can
+// be exercised with an in-memory map instead of a live redis connection.
+func buildNodeRecords(fields map[string]string, now time.Time) []*NodeRecord {
+	ts := now.Format(time.RFC3339)
+	byIP := make(map[string]map[string]string)
+	for key, value := range fields {
+		ip, field, ok := splitNodeField(key)
+		if !ok {
+			continue
+		}
+		if byIP[ip] == nil {
+			byIP[ip] = make(map[string]string)
+		}
+		byIP[ip][field] = value
+	}
+
+	list := make([]*NodeRecord, 0, len(byIP))
+	for ip, ipFields := range byIP {
+		rec := &NodeRecord{Timestamp: ts}
+		rec.Unmarshal(ip, ipFields)
+		list = append(list, rec)
+	}
+	return list
+}
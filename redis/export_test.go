@@ -0,0 +1,27 @@
+package redis
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBuildNodeRecords(t *testing.T) {
+	now := time.Now()
+	fields := map[string]string{
+		"1.2.3.4:port":     "30303",
+		"1.2.3.4:lastBeat": "1000",
+	}
+
+	records := buildNodeRecords(fields, now)
+	if len(records) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(records))
+	}
+
+	rec := records[0]
+	if rec.IP != "1.2.3.4" || rec.Port != "30303" || rec.LastBeat != 1000 {
+		t.Fatalf("unexpected record: %+v", rec)
+	}
+	if rec.Timestamp != now.Format(time.RFC3339) {
+		t.Fatalf("unexpected timestamp: %s", rec.Timestamp)
+	}
+}
@@ -3,35 +3,138 @@ package redis
 import (
 	// "fmt"
 	// "log"
-	// "math/big"
+	"math/big"
+	"net"
+	"sort"
 	"strconv"
 	"strings"
-	// "time"
+	"sync"
+	"time"
 
-	//"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common"
 	//"github.com/ethereumproject/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/p2p/discover"
 	"gopkg.in/redis.v3"
 
 	// "../ethhelp"
 	"../util"
 )
 
+// nodeFieldSep separates the ip from the sub-field ("port"/"lastBeat") in the
+// "nodes" hash's field names. It has to be something that never shows up in
+// an IP address itself, which ":" doesn't satisfy once IPv6 addresses (which
+// are full of colons) are in the mix.
+const nodeFieldSep = "|"
+
+const (
+	defaultVerifyGoodPortTimeout    = 5 * time.Second
+	defaultVerifyGoodPortConcurrency = 10
+)
+
+func nodeField(ip, field string) string {
+	return ip + nodeFieldSep + field
+}
+
+// splitNodeField reverses nodeField, returning the ip and field name it was
+// built from.
+func splitNodeField(key string) (ip, field string, ok bool) {
+	parts := strings.SplitN(key, nodeFieldSep, 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// writeNodeRecord HSets rec's Marshal()ed fields under nodeField(rec.IP, ...)
+// on tx, shared by WriteNode and WriteNodesBatch so both stay in sync with
+// whatever fields NodeRecord grows next.
+func writeNodeRecord(tx *redis.Multi, key string, rec NodeRecord) {
+	for field, value := range rec.Marshal() {
+		tx.HSet(key, nodeField(rec.IP, field), value)
+	}
+}
+
 func (r *RedisClient) WriteNode(ip, port string) error {
 	tx := r.client.Multi()
 	defer tx.Close()
 	//map eth:nodes:ip port 1024 lastBeat 1111111
 	//set ip port 可以联通的
 	now := util.MakeTimestamp() / 1000
+	rec := NodeRecord{IP: ip, TCPPort: port, LastBeat: now}
 
 	_, err := tx.Exec(func() error {
-		tx.HSet(r.formatKey("nodes"), join(ip, "port"), port)
+		writeNodeRecord(tx, r.formatKey("nodes"), rec)
+		r.enrichGeo(tx, ip)
+		return nil
+	})
+	return err
+}
 
-		tx.HSet(r.formatKey("nodes"), join(ip, "lastBeat"), strconv.FormatInt(now, 10))
+// WriteNodesBatch writes many nodes in a single Multi/Exec round trip
+// instead of the one-transaction-per-node cost of calling WriteNode in a
+// loop, which matters once discovery is finding thousands of nodes a
+// minute. A nil or empty nodes slice is a no-op.
+func (r *RedisClient) WriteNodesBatch(nodes []NodeRecord) error {
+	if len(nodes) == 0 {
+		return nil
+	}
+	tx := r.client.Multi()
+	defer tx.Close()
+	now := util.MakeTimestamp() / 1000
+
+	_, err := tx.Exec(func() error {
+		for _, node := range nodes {
+			if node.TCPPort == "" {
+				node.TCPPort = node.Port
+			}
+			node.LastBeat = now
+			writeNodeRecord(tx, r.formatKey("nodes"), node)
+			r.enrichGeo(tx, node.IP)
+		}
 		return nil
 	})
 	return err
 }
 
+// enrichGeo tags ip's node record with its country code and ASN via r.geo,
+// if a GeoLookup has been configured with SetGeoLookup. Lookup failures (a
+// missing/unparseable ip, a private/reserved address, a database that
+// doesn't cover it) are skipped silently rather than failing the write -
+// enrichment is a nice-to-have, not a requirement for storing the node.
+func (r *RedisClient) enrichGeo(tx *redis.Multi, ip string) {
+	if r.geo == nil {
+		return
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return
+	}
+	if country, err := r.geo.Country(parsed); err == nil && country != "" {
+		tx.HSet(r.formatKey("nodes"), nodeField(ip, "country"), country)
+	}
+	if asn, err := r.geo.ASN(parsed); err == nil && asn != 0 {
+		tx.HSet(r.formatKey("nodes"), nodeField(ip, "asn"), strconv.FormatUint(uint64(asn), 10))
+	}
+}
+
+// GetNodesByCountry returns every ip in the "nodes" hash whose enriched
+// country code matches cc, an empty slice if geo enrichment was never
+// enabled or no nodes match.
+func (r *RedisClient) GetNodesByCountry(cc string) (addrs []string) {
+	cmd := r.client.HGetAllMap(r.formatKey("nodes"))
+	if cmd.Err() != nil {
+		return addrs
+	}
+	for key, value := range cmd.Val() {
+		ip, field, ok := splitNodeField(key)
+		if !ok || field != "country" || value != cc {
+			continue
+		}
+		addrs = append(addrs, ip)
+	}
+	return addrs
+}
+
 // func (r *RedisClient) Exist(ip string) bool {
 // 	tx := r.client.Multi()
 // 	defer tx.Close()
@@ -71,22 +174,559 @@ func (r *RedisClient) WriteGoodPort(iport string) {
 		return nil
 	})
 }
-func (r *RedisClient) GetAddrs() (addrs []string) {
-	var c int64
-	for {
-		now := util.MakeTimestamp() / 1000
-		c, keys, err := r.client.Scan(c, r.formatKey("nodes", "*"), now).Result()
 
+// GetGoodPorts returns every "ip:port" entry WriteGoodPort has recorded.
+func (r *RedisClient) GetGoodPorts() []string {
+	cmd := r.client.SMembers(r.formatKey("goodport"))
+	if cmd.Err() != nil {
+		return nil
+	}
+	return cmd.Val()
+}
+
+// RemoveGoodPort drops iport from the goodport set.
+func (r *RedisClient) RemoveGoodPort(iport string) error {
+	return r.client.SRem(r.formatKey("goodport"), iport).Err()
+}
+
+// GoodPortDistribution counts how many goodport entries use each port
+// number, revealing common deployment patterns (e.g. most nodes still on
+// the default 30303). Malformed "ip:port" entries are skipped rather than
+// failing the whole scan.
+func (r *RedisClient) GoodPortDistribution() map[int]int {
+	dist := make(map[int]int)
+	for _, iport := range r.GetGoodPorts() {
+		_, portStr, err := net.SplitHostPort(iport)
 		if err != nil {
-			return addrs
+			continue
 		}
-		for _, key := range keys {
-			m := strings.Split(key, ":")
-			addrs = append(addrs, m[1])
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			continue
 		}
-		if c == 0 {
-			break
+		dist[port]++
+	}
+	return dist
+}
+
+// VerifyGoodPorts dials every entry in the goodport set with the given
+// timeout, up to concurrency dials at once, and removes any that fail to
+// connect. It returns how many entries were pruned. A timeout <= 0 or
+// concurrency <= 0 falls back to sane defaults.
+func (r *RedisClient) VerifyGoodPorts(timeout time.Duration, concurrency int) (pruned int) {
+	if timeout <= 0 {
+		timeout = defaultVerifyGoodPortTimeout
+	}
+	if concurrency <= 0 {
+		concurrency = defaultVerifyGoodPortConcurrency
+	}
+
+	iports := r.GetGoodPorts()
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
+	for _, iport := range iports {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(iport string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			conn, err := net.DialTimeout("tcp", iport, timeout)
+			if err != nil {
+				if rmErr := r.RemoveGoodPort(iport); rmErr == nil {
+					mu.Lock()
+					pruned++
+					mu.Unlock()
+				}
+				return
+			}
+			conn.Close()
+		}(iport)
+	}
+	wg.Wait()
+	return pruned
+}
+
+// WriteChainTip records the highest known chain tip (block number, hash and
+// total difficulty) so it survives a restart instead of the proxy having to
+// regress back to its hardcoded startBlock/startTD.
+func (r *RedisClient) WriteChainTip(number uint64, hash common.Hash, td *big.Int) error {
+	tx := r.client.Multi()
+	defer tx.Close()
+
+	_, err := tx.Exec(func() error {
+		tx.HSet(r.formatKey("chaintip"), "number", strconv.FormatUint(number, 10))
+		tx.HSet(r.formatKey("chaintip"), "hash", hash.Hex())
+		tx.HSet(r.formatKey("chaintip"), "td", td.String())
+		return nil
+	})
+	return err
+}
+
+// GetChainTip reads back the tip written by WriteChainTip. ok is false if
+// no tip has been recorded yet.
+func (r *RedisClient) GetChainTip() (number uint64, hash common.Hash, td *big.Int, ok bool) {
+	cmd := r.client.HGetAllMap(r.formatKey("chaintip"))
+	if cmd.Err() != nil {
+		return 0, hash, nil, false
+	}
+	fields := cmd.Val()
+	if len(fields) == 0 {
+		return 0, hash, nil, false
+	}
+	number, err := strconv.ParseUint(fields["number"], 10, 64)
+	if err != nil {
+		return 0, hash, nil, false
+	}
+	hash = common.HexToHash(fields["hash"])
+	td, ok = new(big.Int).SetString(fields["td"], 10)
+	if !ok {
+		return 0, hash, nil, false
+	}
+	return number, hash, td, true
+}
+
+// IncrNodesDiscovered atomically increments the cumulative count of unique
+// nodes ever discovered and returns the new total, so a restart resumes
+// counting from where it left off instead of dropping back to 0.
+func (r *RedisClient) IncrNodesDiscovered() uint64 {
+	cmd := r.client.Incr(r.formatKey("nodesDiscovered"))
+	if cmd.Err() != nil {
+		return 0
+	}
+	return uint64(cmd.Val())
+}
+
+// GetNodesDiscovered reads back the counter IncrNodesDiscovered maintains,
+// 0 if it has never been incremented.
+func (r *RedisClient) GetNodesDiscovered() uint64 {
+	cmd := r.client.Get(r.formatKey("nodesDiscovered"))
+	if cmd.Err() != nil {
+		return 0
+	}
+	n, _ := strconv.ParseUint(cmd.Val(), 10, 64)
+	return n
+}
+
+// WritePeerStatus records the negotiated handshake state for a peer, keyed
+// by its node ID, so we can later tell which peers sit on the canonical
+// genesis versus a fork. TD is stored as a decimal string since it can
+// exceed 64 bits. The peer's id is also added to a per-genesis set so peers
+// can be bucketed by chain via GetPeersByGenesis, regardless of whether
+// their genesis matches ours - crawling multiple networks means a mismatch
+// is expected, not an error.
+func (r *RedisClient) WritePeerStatus(id string, td *big.Int, head, genesis common.Hash) error {
+	tx := r.client.Multi()
+	defer tx.Close()
+
+	_, err := tx.Exec(func() error {
+		tx.HSet(r.formatKey("peerstatus", id), "td", td.String())
+		tx.HSet(r.formatKey("peerstatus", id), "head", head.Hex())
+		tx.HSet(r.formatKey("peerstatus", id), "genesis", genesis.Hex())
+		tx.SAdd(r.formatKey("genesis", genesis.Hex()), id)
+		return nil
+	})
+	return err
+}
+
+// WriteDialFailures persists the current consecutive-failure count for a
+// node ID, so a restart can rebuild the dial backoff tracker's state instead
+// of hammering dead nodes fresh from zero.
+func (r *RedisClient) WriteDialFailures(id string, count int) error {
+	tx := r.client.Multi()
+	defer tx.Close()
+
+	_, err := tx.Exec(func() error {
+		tx.HSet(r.formatKey("dialfailures"), id, strconv.Itoa(count))
+		return nil
+	})
+	return err
+}
+
+// ClearDialFailures removes id's persisted failure count, called once a
+// dial finally succeeds.
+func (r *RedisClient) ClearDialFailures(id string) error {
+	return r.client.HDel(r.formatKey("dialfailures"), id).Err()
+}
+
+// GetDialFailures reads back every node ID's persisted failure count.
+func (r *RedisClient) GetDialFailures() map[string]int {
+	failures := make(map[string]int)
+	cmd := r.client.HGetAllMap(r.formatKey("dialfailures"))
+	if cmd.Err() != nil {
+		return failures
+	}
+	for id, value := range cmd.Val() {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			continue
 		}
+		failures[id] = n
+	}
+	return failures
+}
+
+// WritePeerScore persists a peer's reputation score, keyed by node ID, so it
+// survives a restart instead of every peer starting back at 0 reputation.
+func (r *RedisClient) WritePeerScore(id string, score int64) error {
+	return r.client.HSet(r.formatKey("peerscores"), id, strconv.FormatInt(score, 10)).Err()
+}
+
+// GetPeerScore reads back the score written by WritePeerScore, 0 if id has
+// never had one recorded.
+func (r *RedisClient) GetPeerScore(id string) int64 {
+	cmd := r.client.HGet(r.formatKey("peerscores"), id)
+	if cmd.Err() != nil {
+		return 0
+	}
+	score, _ := strconv.ParseInt(cmd.Val(), 10, 64)
+	return score
+}
+
+// SeenTx atomically records txHash as seen, expiring after ttl, and reports
+// whether it was already recorded by an earlier call. Used by seenTxFilter
+// as the shared-across-restarts backstop behind its local cache.
+func (r *RedisClient) SeenTx(txHash string, ttl time.Duration) (bool, error) {
+	cmd := r.client.SetNX(r.formatKey("seentx", txHash), "1", ttl)
+	if cmd.Err() != nil {
+		return false, cmd.Err()
+	}
+	return !cmd.Val(), nil
+}
+
+// RecordNodeIP adds ip to the set of distinct IPs seen for node id, bounded
+// to maxIPs entries - once the set reaches that size, further new IPs are
+// dropped rather than growing it without limit, so a churning NAT or a
+// sybil rotating through hosts can't blow up the set forever. It returns
+// the current distinct-IP count for id. maxIPs <= 0 means unbounded.
+func (r *RedisClient) RecordNodeIP(id, ip string, maxIPs int) (int, error) {
+	key := r.formatKey("nodeips", id)
+
+	member := r.client.SIsMember(key, ip)
+	if member.Err() != nil {
+		return 0, member.Err()
+	}
+	if !member.Val() {
+		count := r.client.SCard(key)
+		if count.Err() != nil {
+			return 0, count.Err()
+		}
+		if maxIPs <= 0 || count.Val() < int64(maxIPs) {
+			if err := r.client.SAdd(key, ip).Err(); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	cmd := r.client.SCard(key)
+	if cmd.Err() != nil {
+		return 0, cmd.Err()
+	}
+	return int(cmd.Val()), nil
+}
+
+// GetNodeIPs returns every distinct IP RecordNodeIP has recorded for id.
+func (r *RedisClient) GetNodeIPs(id string) ([]string, error) {
+	cmd := r.client.SMembers(r.formatKey("nodeips", id))
+	if cmd.Err() != nil {
+		return nil, cmd.Err()
+	}
+	return cmd.Val(), nil
+}
+
+// AddBlacklistIP persists ip to the shared blacklist set, so a manually
+// curated block list survives a restart (see blacklist.go's ipBlacklist,
+// which seeds itself from GetBlacklist at startup).
+func (r *RedisClient) AddBlacklistIP(ip string) error {
+	return r.client.SAdd(r.formatKey("blacklist"), ip).Err()
+}
+
+// GetBlacklist returns every IP AddBlacklistIP has recorded.
+func (r *RedisClient) GetBlacklist() ([]string, error) {
+	cmd := r.client.SMembers(r.formatKey("blacklist"))
+	if cmd.Err() != nil {
+		return nil, cmd.Err()
+	}
+	return cmd.Val(), nil
+}
+
+// GetPeersByGenesis returns the ids of peers last seen reporting genesis g,
+// per the set WritePeerStatus maintains. If a peer's genesis were to change
+// between calls its id would remain in the old genesis's set too, but a
+// peer's genesis is effectively immutable in practice so this isn't cleaned
+// up.
+func (r *RedisClient) GetPeersByGenesis(g common.Hash) []string {
+	cmd := r.client.SMembers(r.formatKey("genesis", g.Hex()))
+	if cmd.Err() != nil {
+		return nil
+	}
+	return cmd.Val()
+}
+
+// GetPeerStatus reads back the handshake state written by WritePeerStatus.
+func (r *RedisClient) GetPeerStatus(id string) (td *big.Int, head, genesis common.Hash, err error) {
+	cmd := r.client.HGetAllMap(r.formatKey("peerstatus", id))
+	if cmd.Err() != nil {
+		return nil, head, genesis, cmd.Err()
+	}
+
+	fields := cmd.Val()
+	td, ok := new(big.Int).SetString(fields["td"], 10)
+	if !ok {
+		td = big.NewInt(0)
+	}
+	head = common.HexToHash(fields["head"])
+	genesis = common.HexToHash(fields["genesis"])
+	return td, head, genesis, nil
+}
+
+// isStale reports whether a node last seen at lastBeat (unix seconds) is
+// older than maxAge as of now.
+func isStale(lastBeat, now int64, maxAge time.Duration) bool {
+	return time.Duration(now-lastBeat)*time.Second > maxAge
+}
+
+// PruneStaleNodes removes nodes from the "nodes" hash that haven't reported
+// a lastBeat within maxAge, so GetAddrs stops handing out addresses for
+// peers long gone from the network.
+func (r *RedisClient) PruneStaleNodes(maxAge time.Duration) error {
+	cmd := r.client.HGetAllMap(r.formatKey("nodes"))
+	if cmd.Err() != nil {
+		return cmd.Err()
+	}
+
+	now := util.MakeTimestamp() / 1000
+	stale := make(map[string]bool)
+	for key, value := range cmd.Val() {
+		ip, field, ok := splitNodeField(key)
+		if !ok || field != "lastBeat" {
+			continue
+		}
+		lastBeat, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		if isStale(lastBeat, now, maxAge) {
+			stale[ip] = true
+		}
+	}
+
+	for ip := range stale {
+		if err := r.client.HDel(r.formatKey("nodes"), nodeField(ip, "port"), nodeField(ip, "lastBeat")).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteEnode persists the full enode of a discovered node - its node ID
+// pubkey plus IP/TCP/UDP - so it can be dialed again later, instead of just
+// the bare ip:port pair WriteNode stores.
+func (r *RedisClient) WriteEnode(node *discover.Node) error {
+	tx := r.client.Multi()
+	defer tx.Close()
+
+	id := node.ID.String()
+
+	_, err := tx.Exec(func() error {
+		tx.HSet(r.formatKey("enodes"), join(id, "ip"), node.IP.String())
+		tx.HSet(r.formatKey("enodes"), join(id, "tcp"), strconv.Itoa(int(node.TCP)))
+		tx.HSet(r.formatKey("enodes"), join(id, "udp"), strconv.Itoa(int(node.UDP)))
+		return nil
+	})
+	return err
+}
+
+// GetEnodes reconstructs the discover.Node values previously written by
+// WriteEnode. Records missing a field, or with a field that fails to parse,
+// are skipped rather than aborting the whole scan.
+func (r *RedisClient) GetEnodes() (nodes []*discover.Node) {
+	cmd := r.client.HGetAllMap(r.formatKey("enodes"))
+	if cmd.Err() != nil {
+		return nodes
+	}
+
+	type record struct {
+		ip  string
+		tcp string
+		udp string
+	}
+	records := make(map[string]*record)
+	for key, value := range cmd.Val() {
+		parts := strings.SplitN(key, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		id, field := parts[0], parts[1]
+		rec, ok := records[id]
+		if !ok {
+			rec = &record{}
+			records[id] = rec
+		}
+		switch field {
+		case "ip":
+			rec.ip = value
+		case "tcp":
+			rec.tcp = value
+		case "udp":
+			rec.udp = value
+		}
+	}
+
+	for idHex, rec := range records {
+		id, err := discover.HexID(idHex)
+		if err != nil {
+			continue
+		}
+		ip := net.ParseIP(rec.ip)
+		if ip == nil {
+			continue
+		}
+		tcp, err := strconv.Atoi(rec.tcp)
+		if err != nil {
+			continue
+		}
+		udp, err := strconv.Atoi(rec.udp)
+		if err != nil {
+			continue
+		}
+		nodes = append(nodes, discover.NewNode(id, ip, uint16(udp), uint16(tcp)))
+	}
+	return nodes
+}
+
+// GetEnodesRanked is GetEnodes ordered by the LastBeat of the "nodes" hash
+// record sharing the enode's IP, so a dialer that works through the list
+// tries the freshest nodes first instead of Redis's random hash-scan order.
+// An enode whose IP has no corresponding node record (or one with
+// LastBeat == 0) sorts last.
+func (r *RedisClient) GetEnodesRanked() []*discover.Node {
+	nodes := r.GetEnodes()
+	lastBeat := make(map[string]int64, len(nodes))
+	for _, rec := range r.GetNodeRecords() {
+		lastBeat[rec.IP] = rec.LastBeat
+	}
+	return enodesByFreshness(nodes, lastBeat)
+}
+
+// enodesByFreshness sorts nodes by lastBeat[node.IP] descending, split out
+// from GetEnodesRanked so the ordering can be tested without a live redis
+// connection.
+func enodesByFreshness(nodes []*discover.Node, lastBeat map[string]int64) []*discover.Node {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return lastBeat[nodes[i].IP.String()] > lastBeat[nodes[j].IP.String()]
+	})
+	return nodes
+}
+
+// addrsFromNodeFields collects the distinct ips out of the "nodes" hash's
+// field names, using splitNodeField so IPv6 addresses (which are full of
+// colons themselves) come back intact instead of getting cut apart.
+func addrsFromNodeFields(fields map[string]string) (addrs []string) {
+	seen := make(map[string]bool)
+	for key := range fields {
+		ip, _, ok := splitNodeField(key)
+		if !ok || seen[ip] {
+			continue
+		}
+		seen[ip] = true
+		addrs = append(addrs, ip)
+	}
+	return addrs
+}
+
+func (r *RedisClient) GetAddrs() (addrs []string) {
+	for _, rec := range r.GetNodeRecords() {
+		addrs = append(addrs, rec.IP)
+	}
+	return addrs
+}
+
+// GetAddrsRanked is GetAddrs ordered by LastBeat descending, so a dialer
+// that works through the list tries the freshest nodes first. A record
+// missing its lastBeat field (LastBeat == 0) sorts last.
+func (r *RedisClient) GetAddrsRanked() (addrs []string) {
+	return addrsByFreshness(r.GetNodeRecords())
+}
+
+// addrsByFreshness sorts recs by LastBeat descending and returns their IPs,
+// split out from GetAddrsRanked so the ordering can be tested without a
+// live redis connection.
+func addrsByFreshness(recs []*NodeRecord) (addrs []string) {
+	sort.Slice(recs, func(i, j int) bool { return recs[i].LastBeat > recs[j].LastBeat })
+	for _, rec := range recs {
+		addrs = append(addrs, rec.IP)
+	}
+	return addrs
+}
+
+// GetNodeRecords reads every entry in the "nodes" hash into NodeRecords via
+// NodeRecord.Unmarshal, tolerating legacy entries that only ever had
+// port/lastBeat written.
+func (r *RedisClient) GetNodeRecords() []*NodeRecord {
+	cmd := r.client.HGetAllMap(r.formatKey("nodes"))
+	if cmd.Err() != nil {
+		return nil
+	}
+	return buildNodeRecords(cmd.Val(), time.Now())
+}
+
+// addrsFromNodeFieldsSince is addrsFromNodeFields with a floor on lastBeat,
+// so callers only get nodes seen since a given time. A node with no
+// lastBeat field, or one that fails to parse, is treated as never seen and
+// excluded.
+func addrsFromNodeFieldsSince(fields map[string]string, since int64) (addrs []string) {
+	lastBeats := make(map[string]int64)
+	ips := addrsFromNodeFields(fields)
+	for key, value := range fields {
+		ip, field, ok := splitNodeField(key)
+		if !ok || field != "lastBeat" {
+			continue
+		}
+		if lastBeat, err := strconv.ParseInt(value, 10, 64); err == nil {
+			lastBeats[ip] = lastBeat
+		}
+	}
+	for _, ip := range ips {
+		if lastBeats[ip] >= since {
+			addrs = append(addrs, ip)
+		}
+	}
+	return addrs
+}
+
+// GetAddrsSince returns every ip whose lastBeat is at or after t, letting a
+// caller export only recently-active nodes instead of the whole "nodes"
+// hash.
+func (r *RedisClient) GetAddrsSince(t time.Time) (addrs []string) {
+	cmd := r.client.HGetAllMap(r.formatKey("nodes"))
+	if cmd.Err() != nil {
+		return addrs
+	}
+	return addrsFromNodeFieldsSince(cmd.Val(), t.Unix())
+}
+
+// GetAddrsPage is GetAddrsSince with pagination: it returns up to limit
+// addresses starting at cursor (an index into the sorted, filtered result),
+// plus the cursor to pass on the next call. A returned nextCursor of 0
+// means there's nothing left to page through. A limit <= 0 returns
+// everything from cursor onward in one page.
+func (r *RedisClient) GetAddrsPage(since time.Time, cursor, limit int) (addrs []string, nextCursor int) {
+	all := r.GetAddrsSince(since)
+	sort.Strings(all)
+
+	if cursor < 0 || cursor >= len(all) {
+		return nil, 0
+	}
+	end := len(all)
+	if limit > 0 && cursor+limit < end {
+		end = cursor + limit
+	}
+	addrs = all[cursor:end]
+	if end < len(all) {
+		nextCursor = end
 	}
-	return
+	return addrs, nextCursor
 }
@@ -0,0 +1,123 @@
+package redis
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/discover"
+)
+
+func TestHealthCheckReportsDisconnected(t *testing.T) {
+	r := NewRedisClient(&Config{Endpoint: "127.0.0.1:1", PoolSize: 1}, "test")
+
+	if r.Connected() {
+		t.Fatal("Connected() before any HealthCheck = true, want false")
+	}
+	if err := r.HealthCheck(); err == nil {
+		t.Fatal("HealthCheck() against a closed port succeeded, want error")
+	}
+	if r.Connected() {
+		t.Fatal("Connected() after a failed HealthCheck = true, want false")
+	}
+}
+
+func TestIsStale(t *testing.T) {
+	now := int64(1000000)
+
+	if isStale(now-10, now, 24*time.Hour) {
+		t.Fatal("a node seen 10s ago should not be stale")
+	}
+	if !isStale(now-25*3600, now, 24*time.Hour) {
+		t.Fatal("a node last seen 25h ago should be stale after a 24h maxAge")
+	}
+}
+
+func TestAddrsFromNodeFieldsSince(t *testing.T) {
+	fields := map[string]string{
+		nodeField("1.2.3.4", "port"):     "30303",
+		nodeField("1.2.3.4", "lastBeat"): "1000",
+		nodeField("5.6.7.8", "port"):     "30303",
+		nodeField("5.6.7.8", "lastBeat"): "500",
+	}
+
+	addrs := addrsFromNodeFieldsSince(fields, 900)
+	if len(addrs) != 1 || addrs[0] != "1.2.3.4" {
+		t.Fatalf("expected only 1.2.3.4 to survive the since filter, got %v", addrs)
+	}
+}
+
+func TestAddrsByFreshnessOrdersDescendingAndTreatsZeroAsOldest(t *testing.T) {
+	recs := []*NodeRecord{
+		{IP: "1.2.3.4", LastBeat: 1000},
+		{IP: "5.6.7.8", LastBeat: 0},
+		{IP: "9.9.9.9", LastBeat: 2000},
+	}
+
+	addrs := addrsByFreshness(recs)
+	want := []string{"9.9.9.9", "1.2.3.4", "5.6.7.8"}
+	if len(addrs) != len(want) {
+		t.Fatalf("addrsByFreshness() = %v, want %v", addrs, want)
+	}
+	for i := range want {
+		if addrs[i] != want[i] {
+			t.Fatalf("addrsByFreshness() = %v, want %v", addrs, want)
+		}
+	}
+}
+
+func testNode(t *testing.T, idHex, ip string) *discover.Node {
+	id, err := discover.HexID(idHex)
+	if err != nil {
+		t.Fatalf("HexID(%s): %v", idHex, err)
+	}
+	return discover.NewNode(id, net.ParseIP(ip), 0, 30303)
+}
+
+func TestEnodesByFreshnessOrdersDescending(t *testing.T) {
+	hex := "1111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111"
+	stale := testNode(t, "01"+hex[2:], "1.2.3.4")
+	unknown := testNode(t, "02"+hex[2:], "5.6.7.8")
+	fresh := testNode(t, "03"+hex[2:], "9.9.9.9")
+
+	nodes := []*discover.Node{stale, unknown, fresh}
+	lastBeat := map[string]int64{
+		"1.2.3.4": 1000,
+		"9.9.9.9": 2000,
+	}
+
+	ranked := enodesByFreshness(nodes, lastBeat)
+	want := []*discover.Node{fresh, stale, unknown}
+	if len(ranked) != len(want) {
+		t.Fatalf("enodesByFreshness() = %v, want %v", ranked, want)
+	}
+	for i := range want {
+		if ranked[i] != want[i] {
+			t.Fatalf("enodesByFreshness()[%d] = %v, want %v", i, ranked[i], want[i])
+		}
+	}
+}
+
+func TestAddrsFromNodeFieldsIPv6(t *testing.T) {
+	fields := map[string]string{
+		nodeField("[::1]:30303", "port"):     "30303",
+		nodeField("[::1]:30303", "lastBeat"): "1000000",
+		nodeField("192.168.1.1", "port"):     "30303",
+	}
+
+	addrs := addrsFromNodeFields(fields)
+	if len(addrs) != 2 {
+		t.Fatalf("expected 2 distinct addrs, got %d: %v", len(addrs), addrs)
+	}
+
+	seen := make(map[string]bool)
+	for _, a := range addrs {
+		seen[a] = true
+	}
+	if !seen["[::1]:30303"] {
+		t.Fatal("expected IPv6 address to survive intact")
+	}
+	if !seen["192.168.1.1"] {
+		t.Fatal("expected IPv4 address to survive intact")
+	}
+}
@@ -0,0 +1,99 @@
+package redis
+
+import (
+	"sync"
+	"time"
+
+	"../logger"
+)
+
+// defaultBufferFlushInterval bounds how long a discovered node can sit in a
+// NodeWriteBuffer before it's written out, even if the size threshold below
+// is never reached during a quiet crawl.
+const defaultBufferFlushInterval = 2 * time.Second
+
+// NodeWriteBuffer accumulates discovered nodes and flushes them to Redis via
+// WriteNodesBatch every maxSize entries or flushInterval, whichever comes
+// first, so a busy crawler doesn't pay for one Multi/Exec round trip per
+// discovered node.
+type NodeWriteBuffer struct {
+	client        *RedisClient
+	maxSize       int
+	flushInterval time.Duration
+
+	mu   sync.Mutex
+	buf  []NodeRecord
+	done chan struct{}
+}
+
+// NewNodeWriteBuffer starts a NodeWriteBuffer that flushes to client every
+// maxSize buffered nodes or flushInterval, whichever comes first. A
+// maxSize <= 0 or flushInterval <= 0 falls back to sane defaults.
+func NewNodeWriteBuffer(client *RedisClient, maxSize int, flushInterval time.Duration) *NodeWriteBuffer {
+	if maxSize <= 0 {
+		maxSize = 100
+	}
+	if flushInterval <= 0 {
+		flushInterval = defaultBufferFlushInterval
+	}
+	b := &NodeWriteBuffer{
+		client:        client,
+		maxSize:       maxSize,
+		flushInterval: flushInterval,
+		buf:           make([]NodeRecord, 0, maxSize),
+		done:          make(chan struct{}),
+	}
+	go b.flushLoop()
+	return b
+}
+
+// Add queues a node for writing, flushing immediately if the buffer has
+// reached maxSize.
+func (b *NodeWriteBuffer) Add(ip, port string) {
+	b.mu.Lock()
+	b.buf = append(b.buf, NodeRecord{IP: ip, Port: port})
+	full := len(b.buf) >= b.maxSize
+	b.mu.Unlock()
+
+	if full {
+		b.Flush()
+	}
+}
+
+// Flush writes any buffered nodes to Redis immediately.
+func (b *NodeWriteBuffer) Flush() error {
+	b.mu.Lock()
+	if len(b.buf) == 0 {
+		b.mu.Unlock()
+		return nil
+	}
+	pending := b.buf
+	b.buf = make([]NodeRecord, 0, b.maxSize)
+	b.mu.Unlock()
+
+	if err := b.client.WriteNodesBatch(pending); err != nil {
+		logger.Error("NodeWriteBuffer: flush failed:", err)
+		return err
+	}
+	return nil
+}
+
+func (b *NodeWriteBuffer) flushLoop() {
+	ticker := time.NewTicker(b.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.Flush()
+		case <-b.done:
+			return
+		}
+	}
+}
+
+// Close stops the periodic flush loop and writes out any remaining
+// buffered nodes.
+func (b *NodeWriteBuffer) Close() {
+	close(b.done)
+	b.Flush()
+}
@@ -0,0 +1,58 @@
+package redis
+
+import (
+	"strconv"
+	"testing"
+)
+
+// newBenchRedisClient connects to a local Redis instance for the throughput
+// comparison below, skipping the benchmark rather than failing it when
+// nothing is listening - these benchmarks are meant to be run by hand
+// against a real Redis, not as part of a normal (redis-less) CI run.
+func newBenchRedisClient(b *testing.B) *RedisClient {
+	r := NewRedisClient(&Config{Endpoint: "127.0.0.1:6379", PoolSize: 10}, "benchnodewrite")
+	if err := r.HealthCheck(); err != nil {
+		b.Skipf("no local redis at 127.0.0.1:6379, skipping: %v", err)
+	}
+	return r
+}
+
+// BenchmarkWriteNodeIndividual measures the baseline cost of WriteNode's
+// one-Multi/Exec-round-trip-per-node path.
+func BenchmarkWriteNodeIndividual(b *testing.B) {
+	r := newBenchRedisClient(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.WriteNode("10.0.0.1", strconv.Itoa(i))
+	}
+}
+
+// BenchmarkWriteNodesBatch measures WriteNodesBatch writing the same number
+// of nodes in batches of 100, the throughput improvement NodeWriteBuffer
+// exists to capture (see nodewritebuffer.go).
+func BenchmarkWriteNodesBatch(b *testing.B) {
+	r := newBenchRedisClient(b)
+	const batchSize = 100
+	batch := make([]NodeRecord, batchSize)
+	for i := range batch {
+		batch[i] = NodeRecord{IP: "10.0.0.1", Port: strconv.Itoa(i)}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i += batchSize {
+		r.WriteNodesBatch(batch)
+	}
+}
+
+// BenchmarkNodeWriteBuffer measures the end-to-end cost callers actually pay
+// through NodeWriteBuffer.Add, including its own flush-triggering logic.
+func BenchmarkNodeWriteBuffer(b *testing.B) {
+	r := newBenchRedisClient(b)
+	buf := NewNodeWriteBuffer(r, 100, defaultBufferFlushInterval)
+	defer buf.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf.Add("10.0.0.1", strconv.Itoa(i))
+	}
+	buf.Flush()
+}
@@ -4,15 +4,18 @@ import (
 	// "fmt"
 	// "log"
 	"math/big"
+	"net"
 	"strconv"
 	"strings"
-	// "time"
+	"sync"
+	"time"
 
 	//"github.com/ethereum/go-ethereum/common"
 	//"github.com/ethereumproject/go-ethereum/common"
 	"gopkg.in/redis.v3"
 
 	"../ethhelp"
+	"../logger"
 	// "../util"
 )
 
@@ -105,11 +108,32 @@ type Config struct {
 	Password string `json:"password"`
 	Database int64  `json:"database"`
 	PoolSize int    `json:"poolSize"`
+	// NodeMaxAgeSec is how long a discovered node may go without a fresh
+	// lastBeat before PruneStaleNodes drops it. Zero disables pruning.
+	NodeMaxAgeSec int64 `json:"nodeMaxAgeSec"`
 }
 
 type RedisClient struct {
 	client *redis.Client
 	prefix string
+	geo    GeoLookup
+
+	connMu    sync.RWMutex
+	connected bool // Last HealthCheck result; see Connected.
+}
+
+// GeoLookup resolves a country code and ASN for an IP, used to optionally
+// enrich node records written via WriteNode/WriteNodesBatch. See the geoip
+// package for the MaxMind GeoLite2-backed implementation.
+type GeoLookup interface {
+	Country(ip net.IP) (string, error)
+	ASN(ip net.IP) (uint, error)
+}
+
+// SetGeoLookup enables geo enrichment of node records written from now on.
+// A nil lookup (the default) disables enrichment entirely.
+func (r *RedisClient) SetGeoLookup(g GeoLookup) {
+	r.geo = g
 }
 
 func (r *RedisClient) Client() *redis.Client {
@@ -120,6 +144,47 @@ func (r *RedisClient) Check() (string, error) {
 	return r.client.Ping().Result()
 }
 
+// Connected reports whether the most recent HealthCheck succeeded. It's
+// false until the first HealthCheck call resolves it, so callers that care
+// about the state at startup should call HealthCheck once themselves rather
+// than relying on the zero value.
+func (r *RedisClient) Connected() bool {
+	r.connMu.RLock()
+	defer r.connMu.RUnlock()
+	return r.connected
+}
+
+// HealthCheck pings Redis, updates Connected accordingly, and logs a clear
+// error on failure - or a recovery notice on the first success following a
+// failure - so a dropped connection never passes by unnoticed the way it
+// could when nothing but a fallback path checked Check() once at startup.
+func (r *RedisClient) HealthCheck() error {
+	_, err := r.Check()
+
+	r.connMu.Lock()
+	wasConnected := r.connected
+	r.connected = err == nil
+	r.connMu.Unlock()
+
+	if err != nil {
+		logger.ErrorKV("redis: health check failed", logger.F("err", err))
+	} else if !wasConnected {
+		logger.WarnKV("redis: connection re-established")
+	}
+	return err
+}
+
+// WatchConnection runs HealthCheck every interval for as long as the process
+// lives. The underlying pool already redials lazily on the next command, so
+// this loop's job isn't to force a reconnect itself - it's to keep Connected
+// current and to log the drop/recovery instead of leaving it to only show up
+// as scattered command errors.
+func (r *RedisClient) WatchConnection(interval time.Duration) {
+	for range time.Tick(interval) {
+		r.HealthCheck()
+	}
+}
+
 func (r *RedisClient) BgSave() (string, error) {
 	return r.client.BgSave().Result()
 }
@@ -0,0 +1,12 @@
+package main
+
+const defaultRedisHealthCheckIntervalSeconds = 15
+
+// redisHealthCheckIntervalSeconds is how often red.WatchConnection pings
+// Redis to keep RedisClient.Connected current.
+func redisHealthCheckIntervalSeconds() int {
+	if cfg.RedisHealthCheckIntervalSeconds != 0 {
+		return cfg.RedisHealthCheckIntervalSeconds
+	}
+	return defaultRedisHealthCheckIntervalSeconds
+}
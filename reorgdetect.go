@@ -0,0 +1,72 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const defaultReorgTrackHeights = 64
+
+// reorgDetector flags when a peer announces a different block hash at a
+// height it previously announced via NewBlockHashesMsg - a signal that
+// either the peer, or the network it's relaying for, has reorganized.
+// Only the last K heights are kept per peer, bounding memory as peers
+// announce indefinitely over the life of a connection.
+type reorgDetector struct {
+	mu      sync.Mutex
+	heights int
+	seen    map[string]map[uint64]common.Hash
+	order   map[string][]uint64
+	reorgs  int64
+}
+
+func newReorgDetector(heights int) *reorgDetector {
+	if heights <= 0 {
+		heights = defaultReorgTrackHeights
+	}
+	return &reorgDetector{
+		heights: heights,
+		seen:    make(map[string]map[uint64]common.Hash),
+		order:   make(map[string][]uint64),
+	}
+}
+
+// Observe records that peerID announced hash at height, and reports whether
+// that conflicts with a different hash it previously announced at the same
+// height.
+func (d *reorgDetector) Observe(peerID string, height uint64, hash common.Hash) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	peerSeen, ok := d.seen[peerID]
+	if !ok {
+		peerSeen = make(map[uint64]common.Hash)
+		d.seen[peerID] = peerSeen
+	}
+
+	prev, existed := peerSeen[height]
+	reorged := existed && prev != hash
+	if reorged {
+		d.reorgs++
+	}
+	peerSeen[height] = hash
+
+	if !existed {
+		d.order[peerID] = append(d.order[peerID], height)
+		for len(d.order[peerID]) > d.heights {
+			oldest := d.order[peerID][0]
+			d.order[peerID] = d.order[peerID][1:]
+			delete(peerSeen, oldest)
+		}
+	}
+	return reorged
+}
+
+// Reorgs returns the running count of conflicting announcements observed
+// across all peers.
+func (d *reorgDetector) Reorgs() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.reorgs
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"./logger"
+)
+
+const defaultSeenTxFilterLocalSize = 4096
+
+// seenTxFilter dedups TxMsg transactions across restarts, unlike the
+// per-peer knownTxs sets in ethpeer which are in-memory and reset whenever
+// the process restarts. It's optional (nil when cfg.SeenTxFilterEnabled is
+// false) and bounded in two layers: a local blockDedupe absorbs the common
+// case of the same tx arriving from several peers back-to-back without a
+// Redis round trip, falling through to Redis - a rolling SETNX-with-TTL key
+// per tx hash, so the seen-set ages out on its own - only on a local miss.
+type seenTxFilter struct {
+	local *blockDedupe
+	ttl   time.Duration
+}
+
+// newSeenTxFilter builds a filter with a local cache of localSize entries in
+// front of Redis, where entries expire after ttl.
+func newSeenTxFilter(localSize int, ttl time.Duration) *seenTxFilter {
+	if localSize <= 0 {
+		localSize = defaultSeenTxFilterLocalSize
+	}
+	return &seenTxFilter{local: newBlockDedupe(localSize), ttl: ttl}
+}
+
+// Seen reports whether hash has already been processed, consulting the local
+// cache first and Redis only on a local miss. A miss is recorded in both, so
+// a later duplicate of the same tx is caught locally without another round
+// trip. A Redis error is treated as "not seen" - degrading to duplicate
+// processing is preferable to blocking or dropping a transaction outright.
+func (f *seenTxFilter) Seen(hash common.Hash) bool {
+	if f.local.Seen(hash) {
+		return true
+	}
+	start := time.Now()
+	seen, err := red.SeenTx(hash.Hex(), f.ttl)
+	observeSeenTxRedis(time.Since(start))
+	if err != nil {
+		logger.Error("seenTxFilter: redis err: ", err)
+		return false
+	}
+	return seen
+}
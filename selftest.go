@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	ethpeer "./ethpeer"
+	"./logger"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p"
+)
+
+const selfTestTimeout = 10 * time.Second
+
+// selfTestOutcome is what one side of the connected pair observed, fed back
+// over a shared channel so runSelfTest can assert on both sides without the
+// two p2p.Protocol.Run goroutines racing on shared state.
+type selfTestOutcome struct {
+	side         string
+	handshakeErr error
+	gotBlock     bool
+	gotTD        *big.Int
+}
+
+// selfTestSenderProtocol performs the eth handshake against whatever it's
+// connected to, sends block/blockTD as a NewBlockMsg, then blocks until
+// done is closed so the connection stays open long enough for the receiver
+// to read it.
+//
+// This is deliberately not the real newManspreadingProtocols/handle from
+// protocol.go - those dispatch every message through the single global
+// pxy, which only exists once per process, so two independent in-process
+// instances of the real protocol can't be wired together. selfTest
+// exercises the same ethpeer.Peer handshake and wire format on a
+// standalone pair of peers instead, which is what actually proves the
+// stack works end to end.
+func selfTestSenderProtocol(genesis common.Hash, td *big.Int, head common.Hash, block *types.Block, blockTD *big.Int, outcomes chan<- selfTestOutcome, done <-chan struct{}) p2p.Protocol {
+	return p2p.Protocol{
+		Name:    ethpeer.ProtocolName,
+		Version: ethpeer.ProtocolVersions[0],
+		Length:  ethpeer.ProtocolLengths[0],
+		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			peer := ethpeer.NewPeer(ethpeer.ProtocolVersions[0], p, rw)
+			if err := peer.Handshake(1, td, head, genesis, ethpeer.ForkID{}); err != nil {
+				outcomes <- selfTestOutcome{side: "sender", handshakeErr: err}
+				return err
+			}
+			if err := peer.SendNewBlock(block, blockTD); err != nil {
+				outcomes <- selfTestOutcome{side: "sender", handshakeErr: fmt.Errorf("SendNewBlock: %v", err)}
+				return err
+			}
+			outcomes <- selfTestOutcome{side: "sender"}
+			<-done
+			return nil
+		},
+	}
+}
+
+// selfTestReceiverProtocol performs the eth handshake, then waits for a
+// single NewBlockMsg and reports its decoded TD back on outcomes.
+func selfTestReceiverProtocol(genesis common.Hash, td *big.Int, head common.Hash, outcomes chan<- selfTestOutcome) p2p.Protocol {
+	return p2p.Protocol{
+		Name:    ethpeer.ProtocolName,
+		Version: ethpeer.ProtocolVersions[0],
+		Length:  ethpeer.ProtocolLengths[0],
+		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			peer := ethpeer.NewPeer(ethpeer.ProtocolVersions[0], p, rw)
+			if err := peer.Handshake(1, td, head, genesis, ethpeer.ForkID{}); err != nil {
+				outcomes <- selfTestOutcome{side: "receiver", handshakeErr: err}
+				return err
+			}
+			for {
+				msg, err := rw.ReadMsg()
+				if err != nil {
+					return err
+				}
+				if msg.Code != ethpeer.NewBlockMsg {
+					continue
+				}
+				var got newBlockData
+				if err := msg.Decode(&got); err != nil {
+					outcomes <- selfTestOutcome{side: "receiver", handshakeErr: fmt.Errorf("decode NewBlockMsg: %v", err)}
+					return err
+				}
+				outcomes <- selfTestOutcome{side: "receiver", gotBlock: true, gotTD: got.TD}
+				return nil
+			}
+		},
+	}
+}
+
+// runSelfTest spins up two local p2p.Servers, connects them, performs an
+// eth handshake in both directions and exchanges a single NewBlock,
+// asserting the block's TD survives the round trip. It's meant to sanity
+// check a build end to end - handshake framing, message encode/decode,
+// TCP connection setup - without needing any external peer or a running
+// Redis, so new contributors and CI can run it standalone.
+func runSelfTest() error {
+	genesis := common.HexToHash("0x0102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+
+	senderKey, err := crypto.GenerateKey()
+	if err != nil {
+		return fmt.Errorf("selftest: generating sender key: %v", err)
+	}
+	receiverKey, err := crypto.GenerateKey()
+	if err != nil {
+		return fmt.Errorf("selftest: generating receiver key: %v", err)
+	}
+
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Difficulty: big.NewInt(1)})
+	blockTD := big.NewInt(12345)
+
+	outcomes := make(chan selfTestOutcome, 2)
+	done := make(chan struct{})
+
+	sender := &p2p.Server{Config: p2p.Config{
+		PrivateKey:  senderKey,
+		MaxPeers:    1,
+		NoDiscovery: true,
+		ListenAddr:  "127.0.0.1:0",
+		Protocols:   []p2p.Protocol{selfTestSenderProtocol(genesis, big.NewInt(100), common.Hash{1}, block, blockTD, outcomes, done)},
+		Logger:      log.New(),
+	}}
+	if err := sender.Start(); err != nil {
+		return fmt.Errorf("selftest: starting sender p2p.Server: %v", err)
+	}
+	defer sender.Stop()
+
+	receiver := &p2p.Server{Config: p2p.Config{
+		PrivateKey:  receiverKey,
+		MaxPeers:    1,
+		NoDiscovery: true,
+		ListenAddr:  "127.0.0.1:0",
+		Protocols:   []p2p.Protocol{selfTestReceiverProtocol(genesis, big.NewInt(50), common.Hash{2}, outcomes)},
+		Logger:      log.New(),
+	}}
+	if err := receiver.Start(); err != nil {
+		return fmt.Errorf("selftest: starting receiver p2p.Server: %v", err)
+	}
+	defer receiver.Stop()
+
+	receiver.AddPeer(sender.Self())
+
+	var results []selfTestOutcome
+	timeout := time.After(selfTestTimeout)
+	for len(results) < 2 {
+		select {
+		case r := <-outcomes:
+			results = append(results, r)
+		case <-timeout:
+			return fmt.Errorf("selftest: timed out waiting for handshake/block exchange")
+		}
+	}
+	close(done)
+
+	var receiverResult *selfTestOutcome
+	for i := range results {
+		if results[i].handshakeErr != nil {
+			return fmt.Errorf("selftest: %s: %v", results[i].side, results[i].handshakeErr)
+		}
+		if results[i].side == "receiver" {
+			receiverResult = &results[i]
+		}
+	}
+	if receiverResult == nil || !receiverResult.gotBlock {
+		return fmt.Errorf("selftest: receiver never observed the propagated block")
+	}
+	if receiverResult.gotTD.Cmp(blockTD) != 0 {
+		return fmt.Errorf("selftest: block TD round-tripped as %v, want %v", receiverResult.gotTD, blockTD)
+	}
+
+	logger.Info("selftest: handshake and NewBlock exchange verified end to end")
+	return nil
+}
+
+// selfTestMain runs runSelfTest, prints a pass/fail summary and exits with
+// a matching status code, so --mode=selftest works as a single CI check
+// step rather than the normal long-running crawl.
+func selfTestMain() {
+	if err := runSelfTest(); err != nil {
+		fmt.Println("SELFTEST FAIL:", err)
+		os.Exit(1)
+	}
+	fmt.Println("SELFTEST PASS")
+	os.Exit(0)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// TestEIP155SignerRecoversBothLegacyAndProtectedSenders checks that the
+// EIP155Signer built from Config.ChainID (see applyNetworkConfig) recovers
+// the sender of both a pre-EIP-155 transaction and one signed against the
+// matching chain ID - EIP155Signer.Sender falls back to the homestead
+// scheme for the former, so a single signer suffices for both.
+func TestEIP155SignerRecoversBothLegacyAndProtectedSenders(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	want := crypto.PubkeyToAddress(key.PublicKey)
+
+	signer := types.NewEIP155Signer(big.NewInt(1))
+	to := common.Address{1}
+
+	legacyTx, err := types.SignTx(types.NewTransaction(0, to, big.NewInt(0), 21000, big.NewInt(1), nil), types.HomesteadSigner{}, key)
+	if err != nil {
+		t.Fatalf("signing legacy tx: %v", err)
+	}
+	if got, err := types.Sender(signer, legacyTx); err != nil || got != want {
+		t.Fatalf("Sender(legacy) = %v, %v; want %v, nil", got, err, want)
+	}
+
+	protectedTx, err := types.SignTx(types.NewTransaction(1, to, big.NewInt(0), 21000, big.NewInt(1), nil), signer, key)
+	if err != nil {
+		t.Fatalf("signing EIP-155 tx: %v", err)
+	}
+	if got, err := types.Sender(signer, protectedTx); err != nil || got != want {
+		t.Fatalf("Sender(protected) = %v, %v; want %v, nil", got, err, want)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"./logger"
+)
+
+// statsResponse is the JSON payload served at /stats.
+type statsResponse struct {
+	PeerCount          int            `json:"peerCount"`
+	BestTD             string         `json:"bestTD"`
+	BestHeight         uint64         `json:"bestHeight"`
+	VersionCounts      map[uint32]int `json:"versionCounts"`
+	DedupedBlocks      int64          `json:"dedupedBlocks"`
+	Reorgs             int64          `json:"reorgs"`
+	HeightHistogram    map[string]int `json:"heightHistogram"`
+	GoodPorts          map[string]int `json:"goodPortDistribution"`
+	EclipseDivergences int64          `json:"eclipseDivergences"`
+	NodesDiscovered    uint64         `json:"nodesDiscovered"`
+	BlockSanityRejects int64          `json:"blockSanityRejects"`
+	PropagationP50Ms   int64          `json:"propagationP50Ms"`
+	PropagationP90Ms   int64          `json:"propagationP90Ms"`
+	PropagationP99Ms   int64          `json:"propagationP99Ms"`
+	PropagationSamples int            `json:"propagationSamples"`
+}
+
+func (pxy *proxy) statsHandler(w http.ResponseWriter, r *http.Request) {
+	pxy.lock.RLock()
+	bestTD := pxy.bestState.TD
+	bestHeight := pxy.bestHeader.Number
+	pxy.lock.RUnlock()
+
+	p50, p90, p99 := pxy.propagation.Percentiles()
+
+	resp := statsResponse{
+		PeerCount:          pxy.srv.PeerCount(),
+		VersionCounts:      pxy.ethpeerset.VersionCounts(),
+		DedupedBlocks:      pxy.newBlockDedupe.Deduped(),
+		Reorgs:             pxy.reorgDetector.Reorgs(),
+		HeightHistogram:    make(map[string]int),
+		GoodPorts:          make(map[string]int),
+		EclipseDivergences: pxy.eclipseDivergenceCount,
+		NodesDiscovered:    pxy.NodesDiscovered(),
+		BlockSanityRejects: pxy.BlockSanityRejects(),
+		PropagationP50Ms:   int64(p50 / time.Millisecond),
+		PropagationP90Ms:   int64(p90 / time.Millisecond),
+		PropagationP99Ms:   int64(p99 / time.Millisecond),
+		PropagationSamples: pxy.propagation.Samples(),
+	}
+	for bucket, count := range pxy.HeightHistogram() {
+		key := strconv.FormatUint(bucket, 10)
+		if bucket == UnknownHeightBucket {
+			key = "unknown"
+		}
+		resp.HeightHistogram[key] = count
+	}
+	for port, count := range red.GoodPortDistribution() {
+		resp.GoodPorts[strconv.Itoa(port)] = count
+	}
+	if bestTD != nil {
+		resp.BestTD = bestTD.String()
+	}
+	if bestHeight != nil {
+		resp.BestHeight = bestHeight.Uint64()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Error("stats encode err: ", err)
+	}
+}
+
+// peersHandler serves the peer set sorted by total difficulty descending
+// (via PeerSet.Snapshot), for debugging a crawl in progress. ?limit=N caps
+// how many peers are returned; the default returns every peer.
+func (pxy *proxy) peersHandler(w http.ResponseWriter, r *http.Request) {
+	peers := pxy.ethpeerset.Snapshot()
+	if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && limit > 0 && limit < len(peers) {
+		peers = peers[:limit]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(peers); err != nil {
+		logger.Error("peers encode err: ", err)
+	}
+}
+
+// HeightBucketSize is the granularity HeightHistogram rounds peer head
+// numbers down to.
+const HeightBucketSize = 1000
+
+// UnknownHeightBucket is the HeightHistogram key for peers with no known
+// head TD yet, kept separate from bucket 0 since that would misleadingly
+// suggest they're stuck at genesis.
+const UnknownHeightBucket = ^uint64(0)
+
+// HeightHistogram buckets registered peers by head block number, rounded
+// down to the nearest HeightBucketSize, to visualize how synced the peer
+// population is at a glance.
+func (pxy *proxy) HeightHistogram() map[uint64]int {
+	hist := make(map[uint64]int)
+	for _, p := range pxy.ethpeerset.AllPeer() {
+		if _, td := p.Head(); td == nil {
+			hist[UnknownHeightBucket]++
+			continue
+		}
+		bucket := (p.HeadNumber() / HeightBucketSize) * HeightBucketSize
+		hist[bucket]++
+	}
+	return hist
+}
+
+// startStatsServer exposes crawler stats over HTTP so they can be scraped
+// without touching Redis directly. It only runs when Config.HTTPAddr is set.
+func (pxy *proxy) startStatsServer(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", pxy.statsHandler)
+	mux.HandleFunc("/peers", pxy.peersHandler)
+	mux.HandleFunc("/topology.dot", pxy.dotHandler)
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			logger.Error("stats server err: ", err)
+		}
+	}()
+}
@@ -0,0 +1,54 @@
+package main
+
+import "./logger"
+
+const defaultSybilIPThreshold = 5
+
+// sybilIPThreshold lets seeker.toml override how many distinct IPs a node
+// ID may be seen behind before recordNodeIP flags it as suspicious.
+func sybilIPThreshold() int {
+	if cfg.SybilIPThreshold != 0 {
+		return cfg.SybilIPThreshold
+	}
+	return defaultSybilIPThreshold
+}
+
+const defaultSybilMaxIPsTracked = 32
+
+// sybilMaxIPsTracked lets seeker.toml override how many distinct IPs are
+// retained per node ID in Redis (see RedisClient.RecordNodeIP).
+func sybilMaxIPsTracked() int {
+	if cfg.SybilMaxIPsTracked != 0 {
+		return cfg.SybilMaxIPsTracked
+	}
+	return defaultSybilMaxIPsTracked
+}
+
+// recordNodeIP records remoteIP against id's IP history in redis and warns
+// once id has been seen behind more than sybilIPThreshold() distinct IPs. A
+// node genuinely moving between that many hosts is rare, so it's either a
+// sybil impersonating one ID from many machines or an ID colliding behind
+// NAT churn - either way it's worth flagging for later sybil-detection
+// analysis, not something to act on automatically here.
+func (pxy *proxy) recordNodeIP(id, remoteIP string) {
+	count, err := red.RecordNodeIP(id, remoteIP, sybilMaxIPsTracked())
+	if err != nil {
+		logger.Error("recordNodeIP: redis err: ", err)
+		return
+	}
+	if count > sybilIPThreshold() {
+		logger.WarnKV("recordNodeIP: node ID seen on many distinct IPs, possible sybil",
+			logger.F("id", id), logger.F("distinctIPs", count))
+	}
+}
+
+// IsSuspiciousSybil reports whether id has been seen behind more distinct
+// IPs than sybilIPThreshold() allows, per recordNodeIP's redis-backed IP
+// history.
+func (pxy *proxy) IsSuspiciousSybil(id string) (bool, error) {
+	ips, err := red.GetNodeIPs(id)
+	if err != nil {
+		return false, err
+	}
+	return len(ips) > sybilIPThreshold(), nil
+}
@@ -1,11 +1,14 @@
 package utils
 
 import (
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/BurntSushi/toml"
 	// "log"
 	"os"
 	"path/filepath"
+	"strings"
 )
 type log struct{
 	Dir string `json:"dir"`
@@ -13,23 +16,217 @@ type log struct{
 	Console bool `json:"console"`
 	Num int32 `json:"num"`
 	Size int64 `json:"size"`
+	// Unit is the size unit Size is measured in: "KB", "MB" or "GB".
+	// Defaults to "KB" if empty, matching the previously hardcoded unit.
+	Unit string `json:"unit"`
 	Level string `json:"level"`
 }
+type redisConfig struct {
+	Endpoint string `json:"endpoint"`
+	Password string `json:"password"`
+	Database int64  `json:"database"`
+	PoolSize int    `json:"poolSize"`
+}
 type Config struct {
 	Coin        string         `json:"coin"`
 	BlockNumber int64 `json:"blockNumber"`
 	Log log          `json:"log"`
+	Redis redisConfig `json:"redis"`
+	ListenAddr string `json:"listenAddr"`
+	MaxPeers   int    `json:"maxPeers"`
+	RedialConcurrency int `json:"redialConcurrency"`
+	HTTPAddr   string `json:"httpAddr"`
+	BootNodes  []string `json:"bootNodes"`
+	Trace        bool `json:"trace"`
+	TraceHexDump bool `json:"traceHexDump"`
+	BlockDedupeSize int `json:"blockDedupeSize"`
+	ReorgTrackHeights int `json:"reorgTrackHeights"`
+	DialBackoffBaseSeconds int `json:"dialBackoffBaseSeconds"`
+	DialBackoffMaxSeconds  int `json:"dialBackoffMaxSeconds"`
+
+	NetworkID       uint64 `json:"networkId"`
+	GenesisHash     string `json:"genesisHash"`
+	StartTD         string `json:"startTD"`
+	StartBlock      string `json:"startBlock"`
+	ProtocolVersion uint32 `json:"protocolVersion"`
+
+	// ChainID is the EIP-155 chain ID used to build the types.EIP155Signer
+	// that recovers TxMsg senders. 0 falls back to NetworkID, which is
+	// correct for every network except the handful where the two diverge.
+	ChainID uint64 `json:"chainId"`
+
+	AcceptForeignGenesis bool `json:"acceptForeignGenesis"`
+
+	KeyFile string `json:"keyFile"`
+
+	ObserveOnly bool `json:"observeOnly"`
+
+	// Store selects the NodeStore backend: "redis" (default) or "memory".
+	Store string `json:"store"`
+
+	// NodeFallbackFile is where discovered nodes are appended if Redis is
+	// unreachable at startup, so a crawl in "redis" mode doesn't lose data
+	// when Redis is briefly down.
+	NodeFallbackFile string `json:"nodeFallbackFile"`
+
+	Metrics     bool   `json:"metrics"`
+	MetricsAddr string `json:"metricsAddr"`
+
+	// GeoIPCountryDBPath and GeoIPASNDBPath point at local MaxMind GeoLite2
+	// databases used to tag crawled nodes with country/ASN. Leave empty to
+	// disable geo enrichment.
+	GeoIPCountryDBPath string `json:"geoIPCountryDBPath"`
+	GeoIPASNDBPath     string `json:"geoIPASNDBPath"`
+
+	// PropagationFanout caps how many peers receive a full block broadcast.
+	// 0 (the default) falls back to ethpeer.PropagationFanout's sqrt(N) heuristic.
+	PropagationFanout int `json:"propagationFanout"`
+
+	// RelayTxs re-broadcasts transactions we receive to peers that don't
+	// have them yet (fan-out capped the same way as PropagationFanout),
+	// turning the proxy into a mempool amplifier. Off by default, since a
+	// passive crawler shouldn't normally inject traffic into the network.
+	RelayTxs bool `json:"relayTxs"`
+
+	// StalledPeerWindowSeconds is how long a peer's TD may go without
+	// advancing before it's logged as stalled. 0 disables the check.
+	StalledPeerWindowSeconds int  `json:"stalledPeerWindowSeconds"`
+	DisconnectStalledPeers   bool `json:"disconnectStalledPeers"`
+
+	// CircuitBreakerFailureThreshold, CircuitBreakerCooldownSeconds and
+	// CircuitBreakerMaxBuffered configure the redis.WriteCircuitBreaker
+	// wrapping node writes to Redis. 0 for any of them falls back to that
+	// breaker's own defaults.
+	CircuitBreakerFailureThreshold int `json:"circuitBreakerFailureThreshold"`
+	CircuitBreakerCooldownSeconds  int `json:"circuitBreakerCooldownSeconds"`
+	CircuitBreakerMaxBuffered      int `json:"circuitBreakerMaxBuffered"`
+
+	// EclipseDivergenceBlocks and EclipseDivergenceWindowSeconds gate
+	// eclipse/partition detection: a warning fires once the peer
+	// population's best head differs from the trusted upstream node's head
+	// by more than EclipseDivergenceBlocks for at least
+	// EclipseDivergenceWindowSeconds. Either being 0 disables the check.
+	EclipseDivergenceBlocks        int `json:"eclipseDivergenceBlocks"`
+	EclipseDivergenceWindowSeconds int `json:"eclipseDivergenceWindowSeconds"`
+
+	// ForwardRules turns this crawler into a true relay: each key is a
+	// message code name (StatusMsg, NewBlockMsg, TxMsg, ... see
+	// formateCode in protocol.go) and each value selects which direction
+	// to forward that code's traffic in - "upstream" (downstream peers ->
+	// the upstream node), "downstream" (upstream -> every downstream
+	// peer) or "both". Codes with no entry are never forwarded, which is
+	// the default passive/observe-only behavior.
+	ForwardRules map[string]string `json:"forwardRules"`
+
+	// EnableBlockSanityChecks gates checkBlockSanity (see blocksanity.go):
+	// when true, a NewBlockMsg header failing its number-monotonicity,
+	// future-timestamp or extraData-size checks is rejected and logged
+	// rather than being trusted into bestState.
+	EnableBlockSanityChecks bool `json:"enableBlockSanityChecks"`
+
+	// HandshakeWorkers bounds how many eager handshakes (see handshakePool
+	// in handshakepool.go) run concurrently, so a burst of simultaneous
+	// connections queues instead of spawning one goroutine per peer. <= 0
+	// falls back to defaultHandshakeWorkers.
+	HandshakeWorkers int `json:"handshakeWorkers"`
+
+	// HandshakeQueueSize bounds how many handshakes may be queued waiting
+	// for a free worker before Submit blocks, applying backpressure to the
+	// caller instead of letting the queue grow without limit. <= 0 falls
+	// back to defaultHandshakeQueueSize.
+	HandshakeQueueSize int `json:"handshakeQueueSize"`
+
+	// DiscoveryV5 enables discv5 lookups alongside the v4 discovery table
+	// that's always on in test2 (NoDiscovery is never set). It's wired
+	// straight into p2p.Config.DiscoveryV5, so p2p.Server runs its own v5
+	// listener and folds v5-discovered nodes into the same peer pool as v4
+	// - the two protocols don't replace each other, v5 is purely additive
+	// coverage for nodes that only announce themselves over v5.
+	DiscoveryV5 bool `json:"discoveryV5"`
+
+	// SeenTxFilterEnabled turns on seenTxFilter (see seentxfilter.go):
+	// TxMsg transactions are deduped against a Redis-backed seen-set that
+	// survives restarts, in addition to the in-memory per-peer knownTxs
+	// sets in ethpeer. Off by default since it adds a Redis round trip per
+	// unique transaction.
+	SeenTxFilterEnabled bool `json:"seenTxFilterEnabled"`
+
+	// SeenTxFilterLocalSize bounds the local cache seenTxFilter checks
+	// before falling through to Redis. <= 0 falls back to
+	// defaultSeenTxFilterLocalSize.
+	SeenTxFilterLocalSize int `json:"seenTxFilterLocalSize"`
+
+	// SeenTxFilterTTLSeconds is how long a tx hash stays recorded in
+	// Redis before seenTxFilter will consider it unseen again. <= 0 means
+	// no expiry.
+	SeenTxFilterTTLSeconds int `json:"seenTxFilterTTLSeconds"`
+
+	// TargetPeers narrows startHack's crafted-block broadcast (see
+	// targetPeers in hack.go) down to peers whose node ID starts with one
+	// of these prefixes, to limit the blast radius of a targeted
+	// experiment. Empty means broadcast to every propagation candidate,
+	// the original behavior.
+	TargetPeers []string `json:"targetPeers"`
+
+	// BlacklistIPs are IPs rejected before handshake on both the dial path
+	// and inbound connections (see blacklist.go), for keeping the crawler
+	// away from known-bad or honeypot hosts. This seeds the in-memory
+	// blacklist at startup; ips added later via AddToBlacklist persist to
+	// Redis and are picked up on the next restart too.
+	BlacklistIPs []string `json:"blacklistIPs"`
+
+	// SybilIPThreshold flags a node ID as suspicious once recordNodeIP
+	// (see sybildetect.go) has seen it behind more than this many distinct
+	// IPs. <= 0 falls back to defaultSybilIPThreshold.
+	SybilIPThreshold int `json:"sybilIPThreshold"`
+
+	// SybilMaxIPsTracked bounds how many distinct IPs are retained per
+	// node ID in Redis. <= 0 falls back to defaultSybilMaxIPsTracked.
+	SybilMaxIPsTracked int `json:"sybilMaxIPsTracked"`
+
+	// MaxKnownBlocks bounds how many block hashes each ethpeer.Peer
+	// remembers announcing to avoid re-sending them (see
+	// ethpeer.NewPeerWithLimits). Raising it trades memory - the cost
+	// multiplies by connected peer count - for fewer redundant
+	// re-propagations; lowering it is the reverse, useful on
+	// memory-constrained deployments. <= 0 falls back to
+	// ethpeer.defaultMaxKnownBlocks.
+	MaxKnownBlocks int `json:"maxKnownBlocks"`
+
+	// MaxKnownTxs is MaxKnownBlocks for transaction hashes instead of
+	// blocks. <= 0 falls back to ethpeer.defaultMaxKnownTxs.
+	MaxKnownTxs int `json:"maxKnownTxs"`
+
+	// HeartbeatIntervalSeconds is how often heartbeatPeers pings each
+	// connected peer with a GetBlockHeaders probe to measure round-trip
+	// latency. <= 0 falls back to defaultHeartbeatIntervalSeconds.
+	HeartbeatIntervalSeconds int `json:"heartbeatIntervalSeconds"`
+
+	// HeartbeatMaxMisses is how many consecutive heartbeat probes a peer
+	// may miss before heartbeatPeers disconnects it as unresponsive.
+	// <= 0 falls back to defaultHeartbeatMaxMisses.
+	HeartbeatMaxMisses int `json:"heartbeatMaxMisses"`
+
+	// RedisHealthCheckIntervalSeconds is how often red.WatchConnection
+	// pings Redis in the background to keep RedisClient.Connected current.
+	// <= 0 falls back to defaultRedisHealthCheckIntervalSeconds.
+	RedisHealthCheckIntervalSeconds int `json:"redisHealthCheckIntervalSeconds"`
+
+	// PropagationTrackerBlocks bounds how many distinct block hashes
+	// propagationTracker remembers the first-seen time of. <= 0 falls back
+	// to defaultPropagationTrackerBlocks.
+	PropagationTrackerBlocks int `json:"propagationTrackerBlocks"`
+
+	// PropagationSampleCap bounds how many propagation-delay samples
+	// propagationTracker retains for its percentile stats. <= 0 falls back
+	// to defaultPropagationSampleCap.
+	PropagationSampleCap int `json:"propagationSampleCap"`
 }
 
 func LoadConfig(configFileName string, cfg interface{}) bool {
 
 	var err error
 
-	//configFileName := "api.json"
-	if len(os.Args) > 1 {
-		configFileName = os.Args[1]
-	}
-
 	configFileName, err = filepath.Abs(configFileName)
 	if err != nil {
 		fmt.Printf("LoadConfig", "filepath.Abs err", err)
@@ -49,3 +246,126 @@ func LoadConfig(configFileName string, cfg interface{}) bool {
 
 	return true
 }
+
+var validLogLevels = map[string]bool{"all": true, "debug": true, "info": true, "warn": true, "error": true, "fatal": true, "off": true}
+var validLogUnits = map[string]bool{"": true, "KB": true, "MB": true, "GB": true}
+var validStores = map[string]bool{"": true, "redis": true, "memory": true}
+
+// Validate checks a decoded Config for values that would silently misbehave
+// rather than fail loudly - an unrecognized log level, or a negative count
+// where LoadConfig's zero-value fallbacks (see maxPeers, redialConcurrency
+// in main.go) can't tell "not set" apart from "explicitly zero".
+func (cfg *Config) Validate() error {
+	if cfg.Log.Level != "" && !validLogLevels[cfg.Log.Level] {
+		return fmt.Errorf("config: log.level %q is not one of all/debug/info/warn/error/fatal/off", cfg.Log.Level)
+	}
+	if !validLogUnits[cfg.Log.Unit] {
+		return fmt.Errorf("config: log.unit %q is not one of KB/MB/GB", cfg.Log.Unit)
+	}
+	if cfg.MaxPeers < 0 {
+		return errors.New("config: maxPeers must not be negative")
+	}
+	if cfg.RedialConcurrency < 0 {
+		return errors.New("config: redialConcurrency must not be negative")
+	}
+	if cfg.BlockDedupeSize < 0 {
+		return errors.New("config: blockDedupeSize must not be negative")
+	}
+	if cfg.ReorgTrackHeights < 0 {
+		return errors.New("config: reorgTrackHeights must not be negative")
+	}
+	if cfg.StalledPeerWindowSeconds < 0 {
+		return errors.New("config: stalledPeerWindowSeconds must not be negative")
+	}
+	if cfg.CircuitBreakerFailureThreshold < 0 {
+		return errors.New("config: circuitBreakerFailureThreshold must not be negative")
+	}
+	if cfg.CircuitBreakerCooldownSeconds < 0 {
+		return errors.New("config: circuitBreakerCooldownSeconds must not be negative")
+	}
+	if cfg.CircuitBreakerMaxBuffered < 0 {
+		return errors.New("config: circuitBreakerMaxBuffered must not be negative")
+	}
+	if cfg.EclipseDivergenceBlocks < 0 {
+		return errors.New("config: eclipseDivergenceBlocks must not be negative")
+	}
+	if cfg.EclipseDivergenceWindowSeconds < 0 {
+		return errors.New("config: eclipseDivergenceWindowSeconds must not be negative")
+	}
+	if cfg.DialBackoffBaseSeconds < 0 {
+		return errors.New("config: dialBackoffBaseSeconds must not be negative")
+	}
+	if cfg.DialBackoffMaxSeconds < 0 {
+		return errors.New("config: dialBackoffMaxSeconds must not be negative")
+	}
+	if cfg.GenesisHash != "" {
+		b, err := hex.DecodeString(strings.TrimPrefix(cfg.GenesisHash, "0x"))
+		if err != nil || len(b) != 32 {
+			return fmt.Errorf("config: genesisHash %q must be a 32-byte hex string", cfg.GenesisHash)
+		}
+	}
+	if !validStores[cfg.Store] {
+		return fmt.Errorf("config: store %q is not one of redis/memory", cfg.Store)
+	}
+	if cfg.Redis.Database < 0 {
+		return errors.New("config: redis.database must not be negative")
+	}
+	if cfg.Redis.PoolSize < 0 {
+		return errors.New("config: redis.poolSize must not be negative")
+	}
+	for name, direction := range cfg.ForwardRules {
+		if direction != "upstream" && direction != "downstream" && direction != "both" {
+			return fmt.Errorf("config: forwardRules[%q] %q is not one of upstream/downstream/both", name, direction)
+		}
+	}
+	if cfg.HandshakeWorkers < 0 {
+		return errors.New("config: handshakeWorkers must not be negative")
+	}
+	if cfg.HandshakeQueueSize < 0 {
+		return errors.New("config: handshakeQueueSize must not be negative")
+	}
+	if cfg.SeenTxFilterLocalSize < 0 {
+		return errors.New("config: seenTxFilterLocalSize must not be negative")
+	}
+	if cfg.SeenTxFilterTTLSeconds < 0 {
+		return errors.New("config: seenTxFilterTTLSeconds must not be negative")
+	}
+	if cfg.SybilIPThreshold < 0 {
+		return errors.New("config: sybilIPThreshold must not be negative")
+	}
+	if cfg.SybilMaxIPsTracked < 0 {
+		return errors.New("config: sybilMaxIPsTracked must not be negative")
+	}
+	if cfg.MaxKnownBlocks < 0 {
+		return errors.New("config: maxKnownBlocks must not be negative")
+	}
+	if cfg.MaxKnownTxs < 0 {
+		return errors.New("config: maxKnownTxs must not be negative")
+	}
+	if cfg.HeartbeatIntervalSeconds < 0 {
+		return errors.New("config: heartbeatIntervalSeconds must not be negative")
+	}
+	if cfg.HeartbeatMaxMisses < 0 {
+		return errors.New("config: heartbeatMaxMisses must not be negative")
+	}
+	if cfg.RedisHealthCheckIntervalSeconds < 0 {
+		return errors.New("config: redisHealthCheckIntervalSeconds must not be negative")
+	}
+	if cfg.PropagationTrackerBlocks < 0 {
+		return errors.New("config: propagationTrackerBlocks must not be negative")
+	}
+	if cfg.PropagationSampleCap < 0 {
+		return errors.New("config: propagationSampleCap must not be negative")
+	}
+	return nil
+}
+
+// LoadConfigStrict is LoadConfig plus Validate, returning a descriptive
+// error instead of a bare bool so callers can log exactly what's wrong with
+// the file rather than silently carrying on with a zero Config.
+func LoadConfigStrict(configFileName string, cfg *Config) error {
+	if !LoadConfig(configFileName, cfg) {
+		return fmt.Errorf("config: failed to load %s, see preceding log for details", configFileName)
+	}
+	return cfg.Validate()
+}